@@ -6,4 +6,5 @@ require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/jmoiron/sqlx v1.3.4
 	golang.org/x/text v0.3.8
+	gopkg.in/yaml.v3 v3.0.1
 )
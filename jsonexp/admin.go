@@ -0,0 +1,501 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+// 配置的一个版本
+type ConfigVersion struct {
+	Version int          `json:"version"`
+	Time    time.Time    `json:"time"`
+	Format  ConfigFormat `json:"format"`
+	Source  []byte       `json:"-"`
+	config  *Configuration
+}
+
+func (m *ConfigVersion) Configuration() *Configuration {
+	return m.config
+}
+
+type configEntry struct {
+	current     *ConfigVersion
+	history     []*ConfigVersion // 按版本号从小到大排列，包含current
+	nextVersion int
+}
+
+// 按名称管理一组Configuration，配置的替换是原子的，并保留版本历史用于回滚。
+// 配置被替换时，旧的配置被关闭(停止令牌桶)，回滚时重新启用
+type ConfigStore struct {
+	dict       *Dictionary
+	maxHistory int
+	lock       sync.RWMutex
+	entries    map[string]*configEntry
+}
+
+// 创建配置仓库，maxHistory为每个配置保留的最大版本数，<=0时为10
+func NewConfigStore(dict *Dictionary, maxHistory int) *ConfigStore {
+	if maxHistory <= 0 {
+		maxHistory = 10
+	}
+	return &ConfigStore{dict: dict, maxHistory: maxHistory, entries: make(map[string]*configEntry)}
+}
+
+func (m *ConfigStore) Dictionary() *Dictionary {
+	return m.dict
+}
+
+// 校验配置，校验通过后替换为当前版本。校验失败时当前版本不变
+func (m *ConfigStore) Put(name string, source []byte, format ConfigFormat) (*ConfigVersion, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is empty")
+	}
+	cfg, err := NewConfigurationWithFormat(source, format, m.dict)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		entry = &configEntry{nextVersion: 1}
+		m.entries[name] = entry
+	}
+	ret := &ConfigVersion{Version: entry.nextVersion, Time: time.Now(), Format: format, Source: source, config: cfg}
+	entry.nextVersion++
+	if entry.current != nil {
+		entry.current.config.Close()
+	}
+	entry.current = ret
+	entry.history = append(entry.history, ret)
+	if len(entry.history) > m.maxHistory {
+		entry.history = entry.history[len(entry.history)-m.maxHistory:]
+	}
+	return ret, nil
+}
+
+// 校验配置，返回在字典的快照(Freeze)上解析的Configuration，不影响字典和仓库中的配置
+func (m *ConfigStore) Validate(source []byte, format ConfigFormat) (*Configuration, error) {
+	ret, err := NewConfigurationWithFormat(source, format, m.dict.Freeze())
+	if err != nil {
+		return nil, err
+	}
+	// 只用于校验，停止令牌桶
+	ret.Close()
+	return ret, nil
+}
+
+// 获取当前版本的配置
+func (m *ConfigStore) Get(name string) (*Configuration, bool) {
+	if v, ok := m.Current(name); ok {
+		return v.config, true
+	}
+	return nil, false
+}
+
+func (m *ConfigStore) Current(name string) (*ConfigVersion, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		return nil, false
+	}
+	return entry.current, true
+}
+
+// 配置的历史版本，按版本号从小到大排列
+func (m *ConfigStore) Versions(name string) []*ConfigVersion {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		return nil
+	}
+	ret := make([]*ConfigVersion, len(entry.history))
+	copy(ret, entry.history)
+	return ret
+}
+
+// 回滚到指定版本，version为0时回滚到当前版本的前一个版本
+func (m *ConfigStore) Rollback(name string, version int) (*ConfigVersion, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("configuration %s not found", name)
+	}
+	if version == 0 {
+		for _, v := range entry.history {
+			if v.Version < entry.current.Version && v.Version > version {
+				version = v.Version
+			}
+		}
+		if version == 0 {
+			return nil, fmt.Errorf("configuration %s has no previous version", name)
+		}
+	}
+	for _, v := range entry.history {
+		if v.Version == version {
+			if v != entry.current {
+				entry.current.config.Close()
+				if v.config.limiters != nil {
+					v.config.limiters.reopen()
+				}
+			}
+			entry.current = v
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("version %d of configuration %s not found", version, name)
+}
+
+func (m *ConfigStore) Delete(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if entry, ok := m.entries[name]; ok {
+		entry.current.config.Close()
+		delete(m.entries, name)
+	}
+}
+
+// 所有配置的名称，按名称排序
+func (m *ConfigStore) Names() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := make([]string, 0, len(m.entries))
+	for k := range m.entries {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// 配置仓库的http管理接口，路径相对于挂载点(可以配合http.StripPrefix使用):
+//
+//	GET  /dict                              字典中注册的变量、对象、比较运算符、赋值运算符
+//	GET  /configs                           所有配置的名称和当前版本
+//	GET  /configs/{name}                    配置的表达式组和键值
+//	PUT  /configs/{name}[?format=yaml]      上传配置，校验通过后替换当前版本，?validate=1时只校验
+//	GET  /configs/{name}/source             当前版本的配置源
+//	GET  /configs/{name}/versions           版本历史
+//	POST /configs/{name}/rollback[?version=N] 回滚到指定版本，不指定时回滚到前一个版本
+//	POST /configs/{name}/groups/{group}/dryrun 以请求体(json对象)为上下文试运行表达式组
+//
+// 试运行在事务上下文中进行，结束后回滚; 修改不支持回滚(未实现UndoableObject)的对象属性时，修改会保留
+type AdminHandler struct {
+	store       *ConfigStore
+	maxBodySize int64
+}
+
+const DefaultAdminMaxBodySize int64 = 4 * 1024 * 1024
+
+func NewAdminHandler(store *ConfigStore) *AdminHandler {
+	return &AdminHandler{store: store, maxBodySize: DefaultAdminMaxBodySize}
+}
+
+// 设置请求体的最大字节数，n<=0时为DefaultAdminMaxBodySize
+func (m *AdminHandler) SetMaxBodySize(n int64) {
+	if n <= 0 {
+		n = DefaultAdminMaxBodySize
+	}
+	m.maxBodySize = n
+}
+
+// 读取请求体，超过最大字节数时返回413
+func (m *AdminHandler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, m.maxBodySize))
+	if err != nil {
+		if int64(len(body)) >= m.maxBodySize {
+			writeAdminError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", m.maxBodySize))
+		} else {
+			writeAdminError(w, http.StatusBadRequest, err)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+type adminConfigSummary struct {
+	Name    string    `json:"name"`
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+type adminConfigDetail struct {
+	Name       string                 `json:"name"`
+	Version    int                    `json:"version"`
+	Groups     []string               `json:"groups"`
+	NameValues map[string]interface{} `json:"name_values"`
+	Buckets    []*BucketStat          `json:"buckets,omitempty"`
+}
+
+type adminDictionary struct {
+	Vars     []string `json:"vars"`
+	Objects  []string `json:"objects"`
+	Compares []string `json:"compares"`
+	Assigns  []string `json:"assigns"`
+}
+
+type adminDryRunResult struct {
+	Context map[string]interface{} `json:"context"`
+	Changes []*Change              `json:"changes"`
+	Trace   *Trace                 `json:"trace"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+func (m *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var parts []string
+	for _, v := range strings.Split(r.URL.Path, "/") {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "dict":
+		if checkMethod(w, r, http.MethodGet) {
+			m.serveDictionary(w)
+		}
+	case len(parts) == 1 && parts[0] == "configs":
+		if checkMethod(w, r, http.MethodGet) {
+			m.serveConfigs(w)
+		}
+	case len(parts) == 2 && parts[0] == "configs":
+		switch r.Method {
+		case http.MethodGet:
+			m.serveConfig(w, parts[1])
+		case http.MethodPut, http.MethodPost:
+			m.servePut(w, r, parts[1])
+		default:
+			writeAdminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	case len(parts) == 3 && parts[0] == "configs" && parts[2] == "source":
+		if checkMethod(w, r, http.MethodGet) {
+			m.serveSource(w, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "configs" && parts[2] == "versions":
+		if checkMethod(w, r, http.MethodGet) {
+			m.serveVersions(w, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "configs" && parts[2] == "rollback":
+		if checkMethod(w, r, http.MethodPost) {
+			m.serveRollback(w, r, parts[1])
+		}
+	case len(parts) == 5 && parts[0] == "configs" && parts[2] == "groups" && parts[4] == "dryrun":
+		if checkMethod(w, r, http.MethodPost) {
+			m.serveDryRun(w, r, parts[1], parts[3])
+		}
+	default:
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func sortedStrings(list []string) []string {
+	sort.Strings(list)
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func (m *AdminHandler) serveDictionary(w http.ResponseWriter) {
+	dict := m.store.dict
+	writeAdminJSON(w, http.StatusOK, &adminDictionary{
+		Vars:     sortedStrings(dict.ListVars()),
+		Objects:  sortedStrings(dict.ListObjects()),
+		Compares: sortedStrings(dict.ListCompares()),
+		Assigns:  sortedStrings(dict.ListAssigns()),
+	})
+}
+
+func (m *AdminHandler) serveConfigs(w http.ResponseWriter) {
+	ret := []*adminConfigSummary{}
+	for _, name := range m.store.Names() {
+		if v, ok := m.store.Current(name); ok {
+			ret = append(ret, &adminConfigSummary{Name: name, Version: v.Version, Time: v.Time})
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, ret)
+}
+
+func (m *AdminHandler) current(w http.ResponseWriter, name string) (*ConfigVersion, bool) {
+	v, ok := m.store.Current(name)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("configuration %s not found", name))
+	}
+	return v, ok
+}
+
+func (m *AdminHandler) serveConfig(w http.ResponseWriter, name string) {
+	v, ok := m.current(w, name)
+	if !ok {
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, &adminConfigDetail{
+		Name:       name,
+		Version:    v.Version,
+		Groups:     v.config.ListJsonExpGroups(),
+		NameValues: v.config.NameValues(),
+		Buckets:    v.config.BucketStats(),
+	})
+}
+
+func (m *AdminHandler) serveSource(w http.ResponseWriter, name string) {
+	v, ok := m.current(w, name)
+	if !ok {
+		return
+	}
+	if v.Format == FormatYAML {
+		w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.Write(v.Source)
+}
+
+func (m *AdminHandler) serveVersions(w http.ResponseWriter, name string) {
+	if _, ok := m.current(w, name); !ok {
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, m.store.Versions(name))
+}
+
+// 上传配置的格式: 优先使用format参数，其次根据Content-Type判断
+func uploadFormat(r *http.Request) (ConfigFormat, error) {
+	var ret ConfigFormat
+	if s := r.URL.Query().Get("format"); s != "" {
+		err := ret.UnmarshalText([]byte(s))
+		return ret, err
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "yaml") {
+		ret = FormatYAML
+	}
+	return ret, nil
+}
+
+func (m *AdminHandler) servePut(w http.ResponseWriter, r *http.Request, name string) {
+	format, err := uploadFormat(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	source, ok := m.readBody(w, r)
+	if !ok {
+		return
+	}
+	if validateOnly, _ := strconv.ParseBool(r.URL.Query().Get("validate")); validateOnly {
+		cfg, err := m.store.Validate(source, format)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, &adminConfigDetail{Name: name, Groups: cfg.ListJsonExpGroups(), NameValues: cfg.NameValues()})
+		return
+	}
+	v, err := m.store.Put(name, source, format)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, &adminConfigSummary{Name: name, Version: v.Version, Time: v.Time})
+}
+
+func (m *AdminHandler) serveRollback(w http.ResponseWriter, r *http.Request, name string) {
+	version := 0
+	if s := r.URL.Query().Get("version"); s != "" {
+		var err error
+		if version, err = strconv.Atoi(s); err != nil || version <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid version %s", s))
+			return
+		}
+	}
+	v, err := m.store.Rollback(name, version)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, &adminConfigSummary{Name: name, Version: v.Version, Time: v.Time})
+}
+
+func (m *AdminHandler) serveDryRun(w http.ResponseWriter, r *http.Request, name string, groupName string) {
+	cfg, ok := m.store.Get(name)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("configuration %s not found", name))
+		return
+	}
+	group, ok := cfg.GetJsonExpGroup(groupName)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("group %s not found in configuration %s", groupName, name))
+		return
+	}
+	input := make(map[string]interface{})
+	if body, ok := m.readBody(w, r); !ok {
+		return
+	} else if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &input); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid context, %s", err.Error()))
+			return
+		}
+	}
+
+	ctx := &goutil.DefaultContext{}
+	for k, v := range input {
+		ctx.SetCtxData(k, v)
+	}
+	tx := NewTxContext(ctx)
+	trace := EnableTrace(tx)
+	collector := NewChangeLogCollector()
+	AddContextAssignObserver(tx, collector.Observer())
+	ret := &adminDryRunResult{Trace: trace}
+	if err := group.Execute(tx); err != nil {
+		ret.Error = err.Error()
+	}
+	ret.Changes = collector.Changes()
+	ret.Context = make(map[string]interface{})
+	for k := range input {
+		ret.Context[k], _ = tx.GetCtxData(k)
+	}
+	for _, c := range ret.Changes {
+		ret.Context[c.VarName] = c.NewValue
+	}
+	if v, ok := tx.GetCtxData("$rand"); ok {
+		ret.Context["$rand"] = v
+	}
+	tx.Rollback()
+	writeAdminJSON(w, http.StatusOK, ret)
+}
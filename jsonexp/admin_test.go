@@ -0,0 +1,162 @@
+package jsonexp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, path, body string, ret interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ret != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), ret); err != nil {
+			t.Fatalf("%s %s: %s, %s", method, path, err.Error(), w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func TestAdminHandler(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$city", nil)
+	dict.RegisterVar("$level", nil)
+	store := NewConfigStore(dict, 2)
+	h := NewAdminHandler(store)
+
+	v1 := `{"name1": "value1", "g": [[["$city", "=", "beijing"], ["$level", "=", 1]]]}`
+	v2 := `{"name1": "value2", "g": [[["$city", "=", "beijing"], ["$level", "=", 2]]]}`
+	if code := adminRequest(t, h, "PUT", "/configs/ad", v1, nil); code != http.StatusOK {
+		t.Fatalf("put v1 fail, %d", code)
+	}
+	var summary adminConfigSummary
+	if code := adminRequest(t, h, "PUT", "/configs/ad", v2, &summary); code != http.StatusOK || summary.Version != 2 {
+		t.Fatalf("put v2 fail, %d, %v", code, summary)
+	}
+	var errRet map[string]string
+	if code := adminRequest(t, h, "PUT", "/configs/ad", `{"g": [[["$x", "=", "{{$city|nofunc}}"]]]}`, &errRet); code != http.StatusBadRequest || errRet["error"] == "" {
+		t.Fatalf("invalid config should be rejected, %d", code)
+	}
+	if cfg, _ := store.Get("ad"); cfg == nil {
+		t.Fatalf("config not found")
+	} else if v, _ := cfg.GetNameValue("name1", nil); v != "value2" {
+		t.Fatalf("invalid config swapped in, %v", v)
+	}
+
+	var dr adminDryRunResult
+	if code := adminRequest(t, h, "POST", "/configs/ad/groups/g/dryrun", `{"$city": "beijing"}`, &dr); code != http.StatusOK {
+		t.Fatalf("dryrun fail, %d", code)
+	}
+	if dr.Error != "" || dr.Context["$level"] != float64(2) || len(dr.Changes) != 1 || len(dr.Trace.Events) == 0 {
+		t.Fatalf("unexpected dryrun result %+v", dr)
+	}
+
+	if code := adminRequest(t, h, "POST", "/configs/ad/rollback", "", &summary); code != http.StatusOK || summary.Version != 1 {
+		t.Fatalf("rollback fail, %d, %v", code, summary)
+	}
+	var detail adminConfigDetail
+	adminRequest(t, h, "GET", "/configs/ad", "", &detail)
+	if detail.Version != 1 || detail.NameValues["name1"] != "value1" || len(detail.Groups) != 1 || detail.Groups[0] != "g" {
+		t.Fatalf("unexpected detail %+v", detail)
+	}
+
+	// 只保留2个版本
+	adminRequest(t, h, "PUT", "/configs/ad", v2, nil)
+	var versions []*ConfigVersion
+	adminRequest(t, h, "GET", "/configs/ad/versions", "", &versions)
+	if len(versions) != 2 || versions[0].Version != 2 || versions[1].Version != 3 {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+	if code := adminRequest(t, h, "POST", "/configs/ad/rollback?version=1", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("version 1 should be dropped, %d", code)
+	}
+
+	var d adminDictionary
+	adminRequest(t, h, "GET", "/dict", "", &d)
+	if len(d.Vars) == 0 || len(d.Compares) == 0 || len(d.Assigns) == 0 {
+		t.Fatalf("unexpected dictionary %+v", d)
+	}
+	var list []adminConfigSummary
+	adminRequest(t, h, "GET", "/configs", "", &list)
+	if len(list) != 1 || list[0].Name != "ad" || list[0].Version != 3 {
+		t.Fatalf("unexpected list %v", list)
+	}
+	if code := adminRequest(t, h, "GET", "/configs/none", "", nil); code != http.StatusNotFound {
+		t.Fatalf("expect 404, got %d", code)
+	}
+	if code := adminRequest(t, h, "DELETE", "/dict", "", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect 405, got %d", code)
+	}
+
+	// 只校验的配置不影响字典和仓库
+	if code := adminRequest(t, h, "PUT", "/configs/ad?validate=1", `{"vars": {"$level": "string"}, "g": [[["$level", "=", "x"]]]}`, nil); code != http.StatusOK {
+		t.Fatalf("validate fail, %d", code)
+	}
+	if _, ok := dict.GetVarDecl("$level"); ok {
+		t.Fatalf("validation should not declare variables in the dictionary")
+	}
+	if v, _ := store.Current("ad"); v.Version != 3 {
+		t.Fatalf("validation should not change the current version, %d", v.Version)
+	}
+
+	h.SetMaxBodySize(16)
+	if code := adminRequest(t, h, "PUT", "/configs/ad", v1, nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413, got %d", code)
+	}
+	if code := adminRequest(t, h, "POST", "/configs/ad/groups/g/dryrun", `{"$city": "beijing", "$level": 1}`, nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413, got %d", code)
+	}
+}
+
+// dry run不改变计数器和令牌桶
+func TestAdminDryRunSideEffects(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$user", nil)
+	dict.RegisterVar("$hit", nil)
+	store := NewConfigStore(dict, 0)
+	h := NewAdminHandler(store)
+	source := `{
+		"buckets": {"b": {"qps": 100}, "k": {"qps": 100, "per_key": true}},
+		"g": [[["$user", "ratelimit", "k"], ["$_", "ratelimit", "b"], [["$user", "incr", "1h"], ["$hit", "=", 1]]]]
+	}`
+	if code := adminRequest(t, h, "PUT", "/configs/rl", source, nil); code != http.StatusOK {
+		t.Fatalf("put fail, %d", code)
+	}
+	for i := 0; i < 30; i++ {
+		var dr adminDryRunResult
+		if code := adminRequest(t, h, "POST", "/configs/rl/groups/g/dryrun", `{"$user": "u1"}`, &dr); code != http.StatusOK {
+			t.Fatalf("dryrun fail, %d", code)
+		}
+		if dr.Error != "" || dr.Context["$hit"] != float64(1) {
+			t.Fatalf("dryrun %d should get tokens, %+v", i, dr)
+		}
+	}
+	if n, _ := dict.CounterStore().Count("u1", "1h", dict.Now()); n != 0 {
+		t.Fatalf("dryrun changed the counter to %d", n)
+	}
+	cfg, _ := store.Get("rl")
+	for _, stat := range cfg.BucketStats() {
+		if stat.Allowed != 0 || stat.Rejected != 0 {
+			t.Fatalf("dryrun changed bucket stats %+v", stat)
+		}
+	}
+
+	// 真正的执行仍然消耗令牌和增加计数
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$user", "u1")
+	if err := g.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := dict.CounterStore().Count("u1", "1h", dict.Now()); n != 1 {
+		t.Fatalf("counter = %d, expect 1", n)
+	}
+	if stats := cfg.BucketStats(); stats[0].Allowed != 1 || stats[1].Allowed != 1 {
+		t.Fatalf("unexpected bucket stats %+v %+v", stats[0], stats[1])
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// NewDictionary注册的内置只读对象:
+//
+//	$env.NAME         环境变量NAME，只有通过Dictionary.SetEnvAllowlist允许的环境变量可以读取
+//	$host.name        主机名
+//	$host.ip          第一个非回环的IPv4地址
+//	$proc.version     主模块的版本(来自编译信息)
+//	$proc.path        主模块的路径
+//	$proc.go_version  Go版本
+//	$proc.pid         进程id
+//
+// 属性值在第一次读取时求值，之后不再变化
+const (
+	ObjectEnv  = "$env"
+	ObjectHost = "$host"
+	ObjectProc = "$proc"
+)
+
+// 可选接口，只读对象的属性不能被赋值或删除
+type ReadOnlyObject interface {
+	Object
+	ReadOnly() bool
+}
+
+// 只读对象的公共部分，属性在第一次读取时通过resolve一次性求值
+type builtinObject struct {
+	once    sync.Once
+	resolve func() map[string]interface{}
+	values  map[string]interface{}
+}
+
+func (m *builtinObject) GetPropertyValue(property string, context Context) interface{} {
+	m.once.Do(func() {
+		m.values = m.resolve()
+	})
+	return m.values[property]
+}
+
+func (m *builtinObject) SetPropertyValue(property string, value interface{}, context Context) {
+}
+
+func (m *builtinObject) ReadOnly() bool {
+	return true
+}
+
+// 环境变量对象，只有在白名单中的环境变量可以被读取，白名单默认为空
+type envObject struct {
+	builtinObject
+	allowlist     []string
+	allowlistLock sync.RWMutex
+}
+
+func newEnvObject() *envObject {
+	return &envObject{builtinObject: builtinObject{resolve: func() map[string]interface{} {
+		ret := make(map[string]interface{})
+		for _, kv := range os.Environ() {
+			if i := strings.Index(kv, "="); i > 0 {
+				ret[kv[:i]] = kv[i+1:]
+			}
+		}
+		return ret
+	}}}
+}
+
+// 快照使用的副本，白名单在复制时确定，环境变量与原对象共享同一次求值
+func (m *envObject) clone() *envObject {
+	m.allowlistLock.RLock()
+	defer m.allowlistLock.RUnlock()
+	ret := &envObject{builtinObject: builtinObject{resolve: func() map[string]interface{} {
+		m.once.Do(func() {
+			m.values = m.resolve()
+		})
+		return m.values
+	}}}
+	ret.allowlist = append([]string{}, m.allowlist...)
+	return ret
+}
+
+func (m *envObject) allowed(name string) bool {
+	m.allowlistLock.RLock()
+	defer m.allowlistLock.RUnlock()
+	for _, pattern := range m.allowlist {
+		if pattern == name || strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *envObject) GetPropertyValue(property string, context Context) interface{} {
+	if !m.allowed(property) {
+		return nil
+	}
+	return m.builtinObject.GetPropertyValue(property, context)
+}
+
+func newHostObject() *builtinObject {
+	return &builtinObject{resolve: func() map[string]interface{} {
+		ret := make(map[string]interface{})
+		if name, err := os.Hostname(); err == nil {
+			ret["name"] = name
+		}
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+					ret["ip"] = ipNet.IP.String()
+					break
+				}
+			}
+		}
+		return ret
+	}}
+}
+
+func newProcObject() *builtinObject {
+	return &builtinObject{resolve: func() map[string]interface{} {
+		ret := map[string]interface{}{
+			"go_version": runtime.Version(),
+			"pid":        os.Getpid(),
+		}
+		if info, ok := debug.ReadBuildInfo(); ok {
+			ret["version"] = info.Main.Version
+			ret["path"] = info.Main.Path
+		}
+		return ret
+	}}
+}
+
+func (dict *Dictionary) registerBuiltinObjects() {
+	dict.envObject = newEnvObject()
+	dict.RegisterObject(ObjectEnv, dict.envObject)
+	dict.RegisterObject(ObjectHost, newHostObject())
+	dict.RegisterObject(ObjectProc, newProcObject())
+}
+
+// 设置$env(以及键值中的${NAME})可以读取的环境变量，支持以*结尾的前缀匹配，如APP_*，"*"允许所有环境变量。
+// 默认不能读取任何环境变量，不在白名单中的环境变量读取为空，避免密钥等敏感信息通过宏等方式泄露。
+// names为nil或空列表时不能读取任何环境变量
+func (m *Dictionary) SetEnvAllowlist(names []string) {
+	m.mustNotFrozen("SetEnvAllowlist")
+	m.envObject.allowlistLock.Lock()
+	defer m.envObject.allowlistLock.Unlock()
+	m.envObject.allowlist = append([]string{}, names...)
+	m.changed()
+}
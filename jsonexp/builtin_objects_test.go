@@ -0,0 +1,88 @@
+package jsonexp
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestBuiltinObjects(t *testing.T) {
+	os.Setenv("JSONEXP_TEST_DC", "dc1")
+	os.Setenv("JSONEXP_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("JSONEXP_TEST_DC")
+	defer os.Unsetenv("JSONEXP_TEST_SECRET")
+	dict := NewDictionary()
+	dict.RegisterVar("$tag", nil)
+	ctx := &goutil.DefaultContext{}
+	// 默认不能读取任何环境变量
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_DC", ctx); v != nil {
+		t.Fatalf("environment variables should be denied by default, got %v", v)
+	}
+	dict.SetEnvAllowlist([]string{"*"})
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_DC", ctx); v != "dc1" {
+		t.Fatalf("expect dc1, got %v", v)
+	}
+	if v, _ := dict.GetVarValue("$proc.go_version", ctx); v != runtime.Version() {
+		t.Fatalf("unexpected go version %v", v)
+	}
+	if v, _ := dict.GetVarValue("$proc.pid", ctx); v != os.Getpid() {
+		t.Fatalf("unexpected pid %v", v)
+	}
+	hostname, _ := os.Hostname()
+	if v, _ := dict.GetVarValue("$host.name", ctx); v != hostname {
+		t.Fatalf("unexpected host name %v", v)
+	}
+
+	// 值只求值一次
+	os.Setenv("JSONEXP_TEST_DC", "dc2")
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_DC", ctx); v != "dc1" {
+		t.Fatalf("env should be resolved once, got %v", v)
+	}
+
+	dict.SetEnvAllowlist([]string{"JSONEXP_TEST_D*"})
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$env.JSONEXP_TEST_DC", "=", "dc1"], ["$tag", "=", "{{$env.JSONEXP_TEST_DC}}-{{$env.JSONEXP_TEST_SECRET}}"]],
+			[["$env.JSONEXP_TEST_DC", "=", "dc9"]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	if err := g.Execute(ctx); err == nil {
+		t.Fatalf("read-only object should not be assigned")
+	}
+	if v, _ := ctx.GetCtxData("$tag"); v != "dc1-" {
+		t.Fatalf("secret should not leak, got %v", v)
+	}
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_DC", ctx); v != "dc1" {
+		t.Fatalf("read-only object changed, got %v", v)
+	}
+	dict.SetEnvAllowlist(nil)
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_DC", ctx); v != nil {
+		t.Fatalf("nil allowlist should deny all, got %v", v)
+	}
+	dict.SetEnvAllowlist([]string{"*"})
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_SECRET", ctx); v != "s3cret" {
+		t.Fatalf("* should allow all, got %v", v)
+	}
+
+	// 运行时的值(如请求参数)中的宏不展开，不能借此读取环境变量
+	dict.RegisterVar("$input", nil)
+	cfg, err = NewConfiguration([]byte(`{"g": [[["$tag", "=", "$input"]]]}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ = cfg.GetJsonExpGroup("g")
+	ctx = &goutil.DefaultContext{}
+	ctx.SetCtxData("$input", "{{$env.JSONEXP_TEST_SECRET}}")
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$tag"); v != "{{$env.JSONEXP_TEST_SECRET}}" {
+		t.Fatalf("runtime value should stay literal, got %v", v)
+	}
+}
@@ -0,0 +1,530 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 生成Go代码的选项
+type GoGenOptions struct {
+	Package  string                   // 生成代码的包名，默认为rules
+	TypeName string                   // 生成的类型名，默认为Rules
+	Source   string                   // 配置文件名，只用于生成代码的注释
+	Samples  []map[string]interface{} // 生成的测试使用的样本输入，为空时根据配置中比较运算的常量自动生成
+}
+
+func (m GoGenOptions) withDefaults() GoGenOptions {
+	if m.Package == "" {
+		m.Package = "rules"
+	}
+	if m.TypeName == "" {
+		m.TypeName = "Rules"
+	}
+	return m
+}
+
+// 生成代码的上下文
+type goGen struct {
+	cfg          *Configuration
+	opts         GoGenOptions
+	groupNames   []string
+	methodNames  map[string]string
+	compareNames []string
+	compareIndex map[string]int
+	assignNames  []string
+	assignIndex  map[string]int
+	buf          bytes.Buffer
+}
+
+func newGoGen(cfg *Configuration, opts GoGenOptions) (*goGen, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("nil configuration")
+	}
+	if cfg.limiters != nil {
+		return nil, fmt.Errorf("%s are not supported by generated code", ConfigurationBucketsKey)
+	}
+	opts = opts.withDefaults()
+	if !isGoIdentifier(opts.Package) || !isGoIdentifier(opts.TypeName) {
+		return nil, fmt.Errorf("invalid package name %s or type name %s", opts.Package, opts.TypeName)
+	}
+	ret := &goGen{
+		cfg:          cfg,
+		opts:         opts,
+		groupNames:   cfg.ListJsonExpGroups(),
+		methodNames:  make(map[string]string),
+		compareIndex: make(map[string]int),
+		assignIndex:  make(map[string]int),
+	}
+	used := map[string]bool{"Execute": true, "GroupNames": true}
+	for _, name := range ret.groupNames {
+		method := "Execute" + goExportedName(name)
+		for i := 2; used[method]; i++ {
+			method = fmt.Sprintf("Execute%s%d", goExportedName(name), i)
+		}
+		used[method] = true
+		ret.methodNames[name] = method
+		for _, exp := range cfg.jsonExpGroups[name].group {
+			if meta := exp.meta; !meta.Start.IsZero() || !meta.End.IsZero() || meta.Weight < 100 {
+				return nil, fmt.Errorf("group %s, node %s: start, end and weight are not supported by generated code", name, exp.label())
+			}
+			for _, v := range exp.compareExpList {
+				if _, ok := ret.compareIndex[v.CompareName]; !ok {
+					ret.compareIndex[v.CompareName] = len(ret.compareNames)
+					ret.compareNames = append(ret.compareNames, v.CompareName)
+				}
+			}
+			for _, v := range exp.assignExpList {
+				if _, ok := ret.assignIndex[v.AssignName]; !ok {
+					ret.assignIndex[v.AssignName] = len(ret.assignNames)
+					ret.assignNames = append(ret.assignNames, v.AssignName)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (m *goGen) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&m.buf, format, args...)
+}
+
+func (m *goGen) header() {
+	m.printf("// Code generated by jsonexpgen")
+	if m.opts.Source != "" {
+		m.printf(" from %s", m.opts.Source)
+	}
+	m.printf(". DO NOT EDIT.\n\npackage %s\n\n", m.opts.Package)
+}
+
+func (m *goGen) formatted() ([]byte, error) {
+	ret, err := format.Source(m.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated source fail, %s", err.Error())
+	}
+	return ret, nil
+}
+
+// 将配置中的表达式组生成为Go代码。
+// 生成的类型为每个表达式组提供一个执行方法，运算函数在创建时从字典中按名称获取，
+// 变量、宏、对象属性、变量声明的处理与JsonExpGroup.Execute相同，赋值观察者收到的事件和有id的节点的错误(*NodeError)
+// 也包含节点的id。执行跟踪(EnableTrace)只记录变量的求值，不记录节点、比较和赋值，也不更新NodeStats的统计。
+// 通过SetTransactional、SetLimits设置的表达式组选项不在配置中，不会生成到代码中
+func GenerateGo(cfg *Configuration, opts GoGenOptions) ([]byte, error) {
+	g, err := newGoGen(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	t := g.opts.TypeName
+	lower := strings.ToLower(t[:1]) + t[1:]
+	g.header()
+	g.printf("import (\n\t\"fmt\"\n\n\t\"github.com/truexf/goutil/jsonexp\"\n)\n\n")
+
+	g.printf("// %s 由配置生成的表达式组，求值和赋值的行为与jsonexp.JsonExpGroup.Execute相同\n", t)
+	g.printf("type %s struct {\n\tdict *jsonexp.Dictionary\n\tcompares []jsonexp.CompareFunc\n\tassigns []jsonexp.AssignFunc\n}\n\n", t)
+	g.printf("var %sCompareNames = %s\n\n", lower, goStringSlice(g.compareNames))
+	g.printf("var %sAssignNames = %s\n\n", lower, goStringSlice(g.assignNames))
+	if decls := g.varDecls(); len(decls) > 0 {
+		g.printf("var %sVarDecls = map[string]*jsonexp.VarDecl{\n", lower)
+		for _, decl := range decls {
+			g.printf("\t%s: {Name: %s, Type: jsonexp.%s, Default: %s, HasDefault: %v},\n", strconv.Quote(decl.Name),
+				strconv.Quote(decl.Name), goVarTypeName(decl.Type), goLiteral(decl.Default), decl.HasDefault)
+		}
+		g.printf("}\n\n")
+	}
+
+	g.printf("// New%s 按名称获取配置中用到的运算函数\n", t)
+	g.printf("func New%s(dict *jsonexp.Dictionary) (*%s, error) {\n", t, t)
+	g.printf("\tif dict == nil {\n\t\treturn nil, fmt.Errorf(\"nil dict\")\n\t}\n")
+	g.printf("\tret := &%s{dict: dict}\n", t)
+	g.printf("\tfor _, name := range %sCompareNames {\n", lower)
+	g.printf("\t\tfn, ok := dict.GetCompareFunc(name)\n\t\tif !ok {\n\t\t\treturn nil, fmt.Errorf(\"compare name %%s not found\", name)\n\t\t}\n")
+	g.printf("\t\tret.compares = append(ret.compares, fn)\n\t}\n")
+	g.printf("\tfor _, name := range %sAssignNames {\n", lower)
+	g.printf("\t\tfn, ok := dict.GetAssignFunc(name)\n\t\tif !ok {\n\t\t\treturn nil, fmt.Errorf(\"assign name %%s not found\", name)\n\t\t}\n")
+	g.printf("\t\tret.assigns = append(ret.assigns, fn)\n\t}\n")
+	g.printf("\treturn ret, nil\n}\n\n")
+
+	g.printf("func (m *%s) compare(i int, left string, right interface{}, context jsonexp.Context) bool {\n", t)
+	g.printf("\tok, err := m.dict.CompareWith(m.compares[i], left, right, context)\n\treturn err == nil && ok\n}\n\n")
+
+	g.printf("// GroupNames 返回所有表达式组的名称\n")
+	g.printf("func (m *%s) GroupNames() []string {\n\treturn %s\n}\n\n", t, goStringSlice(g.groupNames))
+
+	g.printf("// Execute 按名称执行表达式组\n")
+	g.printf("func (m *%s) Execute(groupName string, context jsonexp.Context) error {\n\tswitch groupName {\n", t)
+	for _, name := range g.groupNames {
+		g.printf("\tcase %s:\n\t\treturn m.%s(context)\n", strconv.Quote(name), g.methodNames[name])
+	}
+	g.printf("\t}\n\treturn fmt.Errorf(\"jsonexp group %%s not found\", groupName)\n}\n")
+
+	for _, name := range g.groupNames {
+		g.group(name)
+	}
+	return g.formatted()
+}
+
+func (m *goGen) varDecls() []*VarDecl {
+	ret := make([]*VarDecl, 0, len(m.cfg.varDecls))
+	for _, decl := range m.cfg.varDecls {
+		ret = append(ret, decl)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (m *goGen) group(name string) {
+	group := m.cfg.jsonExpGroups[name]
+	m.printf("\n// %s 执行表达式组%s\n", m.methodNames[name], name)
+	m.printf("func (m *%s) %s(context jsonexp.Context) error {\n", m.opts.TypeName, m.methodNames[name])
+	m.printf("\tjsonexp.PrepareContext(context)\n")
+	if len(m.cfg.varDecls) > 0 {
+		lower := strings.ToLower(m.opts.TypeName[:1]) + m.opts.TypeName[1:]
+		m.printf("\tdefer jsonexp.SetContextVarDecls(context, %sVarDecls)()\n", lower)
+	}
+	for i, exp := range group.group {
+		if exp.meta.ID != "" {
+			m.printf("\t// node %d (%s)\n", i, exp.meta.ID)
+		} else {
+			m.printf("\t// node %d\n", i)
+		}
+		if !exp.meta.Enabled {
+			m.printf("\t// disabled\n")
+			continue
+		}
+		var conds []string
+		for _, v := range exp.compareExpList {
+			m.printf("\t// %s\n", expComment(v.Left, v.CompareName, v.Right))
+			conds = append(conds, fmt.Sprintf("m.compare(%d, %s, %s, context)", m.compareIndex[v.CompareName], strconv.Quote(v.Left), goLiteral(v.Right)))
+		}
+		indent := "\t"
+		if len(conds) > 0 {
+			m.printf("\tif %s {\n", strings.Join(conds, " && "))
+			indent = "\t\t"
+		}
+		for _, v := range exp.assignExpList {
+			m.printf("%s// %s\n", indent, expComment(v.Left, v.AssignName, v.Right))
+			m.printf("%sif err := m.dict.AssignWith(%s, m.assigns[%d], %s, %s, context, %s, %d, %s); err != nil {\n",
+				indent, strconv.Quote(v.AssignName), m.assignIndex[v.AssignName], strconv.Quote(v.Left), goLiteral(v.Right), strconv.Quote(name), i, strconv.Quote(exp.meta.ID))
+			m.printf("%s\treturn err\n%s}\n", indent, indent)
+			m.printf("%sif jsonexp.IsBreak(context) {\n%s\treturn nil\n%s}\n", indent, indent, indent)
+		}
+		if len(conds) > 0 {
+			m.printf("\t}\n")
+		}
+		// 无条件执行的节点，最后一个赋值之后已经检查过$break
+		if len(conds) > 0 || len(exp.assignExpList) == 0 {
+			m.printf("\tif jsonexp.IsBreak(context) {\n\t\treturn nil\n\t}\n")
+		}
+	}
+	m.printf("\treturn nil\n}\n")
+}
+
+// 生成测试代码，测试使用样本输入分别执行生成的代码和JsonExpGroup.Execute，比较两者的错误和赋值结果。
+// 测试使用的字典由包级变量new<TypeName>TestDictionary创建，默认为jsonexp.NewDictionary，
+// 配置使用了自定义变量、运算符或者对象时，可以在非生成的代码中通过init函数替换
+func GenerateGoTest(cfg *Configuration, opts GoGenOptions) ([]byte, error) {
+	g, err := newGoGen(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	t := g.opts.TypeName
+	lower := strings.ToLower(t[:1]) + t[1:]
+	source := g.configSource()
+	samples := g.opts.Samples
+	if len(samples) == 0 {
+		samples = g.samples()
+	}
+	sampleList := make([]interface{}, len(samples))
+	for i, v := range samples {
+		sampleList[i] = v
+	}
+
+	g.header()
+	g.printf("import (\n\t\"reflect\"\n\t\"testing\"\n\n\t\"github.com/truexf/goutil/jsonexp\"\n)\n\n")
+	g.printf("var new%sTestDictionary = jsonexp.NewDictionary\n\n", t)
+	if strings.Contains(source, "`") {
+		g.printf("const %sTestConfig = %s\n\n", lower, strconv.Quote(source))
+	} else {
+		g.printf("const %sTestConfig = `%s`\n\n", lower, source)
+	}
+	g.printf("var %sTestVars = %s\n\n", lower, goStringSlice(g.variables(false)))
+	g.printf("var %sTestAssigned = %s\n\n", lower, goStringSlice(g.variables(true)))
+	g.printf("var %sTestSamples = []map[string]interface{}{\n", lower)
+	for _, v := range sampleList {
+		g.printf("\t%s,\n", strings.TrimPrefix(goLiteral(v), "map[string]interface{}"))
+	}
+	g.printf("}\n\n")
+
+	g.printf("func Test%sMatchesJsonExp(t *testing.T) {\n", t)
+	g.printf("\tdict := new%sTestDictionary()\n", t)
+	g.printf("\tregistered := make(map[string]bool)\n\tfor _, v := range dict.ListVars() {\n\t\tregistered[v] = true\n\t}\n")
+	g.printf("\tfor _, v := range %sTestVars {\n\t\tif !registered[v] {\n\t\t\tdict.RegisterVar(v, nil)\n\t\t}\n\t}\n", lower)
+	g.printf("\tcfg, err := jsonexp.NewConfiguration([]byte(%sTestConfig), dict)\n\tif err != nil {\n\t\tt.Fatal(err)\n\t}\n", lower)
+	g.printf("\tgenerated, err := New%s(dict)\n\tif err != nil {\n\t\tt.Fatal(err)\n\t}\n", t)
+	g.printf("\tfor _, name := range generated.GroupNames() {\n")
+	g.printf("\t\tgroup, ok := cfg.GetJsonExpGroup(name)\n\t\tif !ok {\n\t\t\tt.Fatalf(\"group %%s not found\", name)\n\t\t}\n")
+	g.printf("\t\tfor i, sample := range %sTestSamples {\n", lower)
+	g.printf("\t\t\twant := &jsonexp.DefaultContext{}\n\t\t\tgot := &jsonexp.DefaultContext{}\n")
+	g.printf("\t\t\tfor k, v := range sample {\n\t\t\t\twant.SetCtxData(k, v)\n\t\t\t\tgot.SetCtxData(k, v)\n\t\t\t}\n")
+	g.printf("\t\t\twantErr := group.Execute(want)\n\t\t\tgotErr := generated.Execute(name, got)\n")
+	g.printf("\t\t\tif (wantErr == nil) != (gotErr == nil) {\n\t\t\t\tt.Fatalf(\"group %%s, sample %%d, error: want %%v, got %%v\", name, i, wantErr, gotErr)\n\t\t\t}\n")
+	g.printf("\t\t\tfor _, v := range %sTestAssigned {\n", lower)
+	g.printf("\t\t\t\twantValue, _ := want.GetCtxData(v)\n\t\t\t\tgotValue, _ := got.GetCtxData(v)\n")
+	g.printf("\t\t\t\tif !reflect.DeepEqual(wantValue, gotValue) {\n\t\t\t\t\tt.Fatalf(\"group %%s, sample %%d, %%s: want %%#v, got %%#v\", name, i, v, wantValue, gotValue)\n\t\t\t\t}\n")
+	g.printf("\t\t\t}\n\t\t}\n\t}\n}\n")
+	return g.formatted()
+}
+
+// 测试使用的配置，只包含表达式组和变量声明，每个表达式节点占一行
+func (m *goGen) configSource() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, name := range m.groupNames {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "\n\t%s: [", compactJSON(name))
+		for j, node := range m.cfg.jsonExpGroups[name].groupSource.([]interface{}) {
+			if j > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("\n\t\t" + compactJSON(node))
+		}
+		sb.WriteString("\n\t]")
+	}
+	if len(m.cfg.varDecls) > 0 {
+		vars := make(map[string]interface{})
+		for _, decl := range m.cfg.varDecls {
+			v := map[string]interface{}{"type": decl.Type.String()}
+			if decl.HasDefault {
+				v["default"] = decl.Default
+			}
+			vars[decl.Name] = v
+		}
+		if len(m.groupNames) > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "\n\t%s: %s", compactJSON(ConfigurationVarsKey), compactJSON(vars))
+	}
+	sb.WriteString("\n}")
+	return sb.String()
+}
+
+// 表达式中出现的简单变量(不包括对象属性)，assigned为true时只返回被赋值的变量
+func (m *goGen) variables(assigned bool) []string {
+	set := map[string]bool{"$break": true}
+	add := func(v interface{}) {
+		if s, ok := v.(string); ok && len(s) > 1 && s[0] == '$' && !strings.Contains(s, ".") {
+			set[s] = true
+		}
+	}
+	for _, name := range m.groupNames {
+		for _, exp := range m.cfg.jsonExpGroups[name].group {
+			for _, v := range exp.assignExpList {
+				add(v.Left)
+				if !assigned {
+					add(v.Right)
+				}
+			}
+			if assigned {
+				continue
+			}
+			for _, v := range exp.compareExpList {
+				add(v.Left)
+				add(v.Right)
+			}
+		}
+	}
+	ret := make([]string, 0, len(set))
+	for k := range set {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// 根据比较运算的常量右值生成样本输入: 一个只包含$rand的基础样本，一个所有变量都取比较常量的样本，
+// 以及对每个变量的每个候选值(常量本身，数值常量加减1，字符串常量的空值)在基础样本上的变化
+func (m *goGen) samples() []map[string]interface{} {
+	candidates := make(map[string][]interface{})
+	primary := make(map[string]interface{})
+	seen := make(map[string]map[string]bool)
+	add := func(name string, v interface{}) {
+		if seen[name] == nil {
+			seen[name] = make(map[string]bool)
+		}
+		key := fmt.Sprintf("%#v", v)
+		if !seen[name][key] {
+			seen[name][key] = true
+			candidates[name] = append(candidates[name], v)
+		}
+	}
+	for _, name := range m.groupNames {
+		for _, exp := range m.cfg.jsonExpGroups[name].group {
+			for _, v := range exp.compareExpList {
+				if strings.Contains(v.Left, ".") {
+					continue
+				}
+				if s, ok := v.Right.(string); ok && (len(s) > 1 && s[0] == '$' || hasMacro(s)) {
+					continue
+				}
+				if _, ok := primary[v.Left]; !ok {
+					primary[v.Left] = v.Right
+				}
+				switch r := v.Right.(type) {
+				case float64:
+					add(v.Left, r-1)
+					add(v.Left, r)
+					add(v.Left, r+1)
+				case string:
+					items := strings.Split(r, ",")
+					primary[v.Left] = items[0]
+					for _, item := range items {
+						add(v.Left, item)
+					}
+					add(v.Left, "")
+				default:
+					add(v.Left, r)
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(candidates))
+	for k := range candidates {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	base := map[string]interface{}{"$rand": float64(50)}
+	ret := []map[string]interface{}{base}
+	if len(names) > 1 {
+		all := map[string]interface{}{"$rand": base["$rand"]}
+		for _, name := range names {
+			all[name] = primary[name]
+		}
+		ret = append(ret, all)
+	}
+	for _, name := range names {
+		for _, v := range candidates[name] {
+			sample := map[string]interface{}{"$rand": base["$rand"]}
+			sample[name] = v
+			ret = append(ret, sample)
+		}
+	}
+	return ret
+}
+
+func compactJSON(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSpace(buf.String())
+}
+
+func expComment(left, op string, right interface{}) string {
+	return compactJSON([]interface{}{left, op, right})
+}
+
+func goStringSlice(list []string) string {
+	items := make([]string, len(list))
+	for i, v := range list {
+		items[i] = strconv.Quote(v)
+	}
+	return "[]string{" + strings.Join(items, ", ") + "}"
+}
+
+func goVarTypeName(tp VarType) string {
+	switch tp {
+	case VarStr:
+		return "VarStr"
+	case VarInt:
+		return "VarInt"
+	case VarFloat:
+		return "VarFloat"
+	case VarSlice:
+		return "VarSlice"
+	case VarBool:
+		return "VarBool"
+	}
+	return "VarInvalid"
+}
+
+// 将配置中的值转换为Go字面量
+func goLiteral(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return "float64(" + strconv.FormatFloat(val, 'g', -1, 64) + ")"
+	case int64:
+		return "int64(" + strconv.FormatInt(val, 10) + ")"
+	case int:
+		return strconv.Itoa(val)
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			items[i] = goLiteral(item)
+		}
+		return "[]interface{}{" + strings.Join(items, ", ") + "}"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = strconv.Quote(k) + ": " + goLiteral(val[k])
+		}
+		return "map[string]interface{}{" + strings.Join(items, ", ") + "}"
+	}
+	return fmt.Sprintf("%#v", v)
+}
+
+// 将表达式组名称转换为导出的Go标识符，如my_json_exp_group转换为MyJsonExpGroup
+func goExportedName(name string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	ret := sb.String()
+	if ret == "" || !unicode.IsUpper([]rune(ret)[0]) {
+		ret = "Group" + ret
+	}
+	return ret
+}
+
+func isGoIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || r == '_' || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
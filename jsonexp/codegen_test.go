@@ -0,0 +1,160 @@
+package jsonexp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestGenerateGo(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$my_var", nil)
+	dict.RegisterVar("$city", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"vars": {"$age": {"type": "int", "default": 18}},
+		"my-group": [
+			[["$city", "in", "beijing,shanghai"], ["$age", ">=", 20], [["$my_var", "=", "{{$city}}"], ["$break", "=", 1]]],
+			[["$age", "+=", 1]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	opts := GoGenOptions{Package: "rules", TypeName: "CityRules", Source: "city.json"}
+	code, err := GenerateGo(cfg, opts)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	src := string(code)
+	for _, s := range []string{
+		"// Code generated by jsonexpgen from city.json. DO NOT EDIT.",
+		`var cityRulesCompareNames = []string{"in", ">="}`,
+		`var cityRulesAssignNames = []string{"=", "+="}`,
+		`{Name: "$age", Type: jsonexp.VarInt, Default: int64(18), HasDefault: true}`,
+		"func (m *CityRules) ExecuteMyGroup(context jsonexp.Context) error {",
+		"defer jsonexp.SetContextVarDecls(context, cityRulesVarDecls)()",
+		`if m.compare(0, "$city", "beijing,shanghai", context) && m.compare(1, "$age", float64(20), context) {`,
+		`m.dict.AssignWith("+=", m.assigns[1], "$age", float64(1), context, "my-group", 1, "")`,
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatalf("generated code does not contain %s", s)
+		}
+	}
+
+	idCfg, err := NewConfiguration([]byte(`{"g": [{"id": "inc", "then": [["$age", "+=", 1]]}]}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err := GenerateGo(idCfg, opts); err != nil || !strings.Contains(string(code), `context, "g", 0, "inc")`) {
+		t.Fatalf("node id not passed to AssignWith, %v", err)
+	}
+
+	testCode, err := GenerateGoTest(cfg, opts)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.Contains(string(testCode), `{"$age": float64(20), "$city": "beijing", "$rand": float64(50)},`) {
+		t.Fatalf("sample not generated")
+	}
+	files := map[string][]byte{"city_gen.go": code, "city_gen_test.go": testCode}
+	if out, err := runGenerated(t, "TestCityRulesMatchesJsonExp", files); err != nil {
+		t.Fatalf("%s\n%s", err.Error(), out)
+	}
+	// 生成的代码与配置不一致时生成的测试失败
+	files["city_gen.go"] = []byte(strings.Replace(src, `"$age", float64(1), context`, `"$age", float64(2), context`, 1))
+	if out, err := runGenerated(t, "TestCityRulesMatchesJsonExp", files); err == nil || !strings.Contains(out, "$age: want") {
+		t.Fatalf("generated test should detect the mismatch, %v\n%s", err, out)
+	}
+}
+
+// 在模块内的临时目录中vet并运行生成的代码和测试，生成的测试用相同的样本比较生成代码与JsonExpGroup.Execute的结果
+func runGenerated(t *testing.T, testName string, files map[string][]byte) (string, error) {
+	if testing.Short() {
+		t.Skip("skip building generated code in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	// 以_开头的目录不会被./...匹配
+	dir, err := ioutil.TempDir(".", "_codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"vet", "."}, {"test", "-count=1", "-v", "-run", "^" + testName + "$", "."}} {
+		cmd := exec.Command(goBin, args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return string(out), fmt.Errorf("go %s fail, %s", args[0], err.Error())
+		}
+		if args[0] == "test" && !strings.Contains(string(out), "--- PASS: "+testName) {
+			return string(out), fmt.Errorf("generated test %s not run", testName)
+		}
+	}
+	return "", nil
+}
+
+func TestCompareWithAssignWith(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$n", nil)
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$n", 3)
+	collector := NewChangeLogCollector()
+	AddContextAssignObserver(ctx, collector.Observer())
+	cmp, _ := dict.GetCompareFunc(">")
+	if ok, err := dict.CompareWith(cmp, "$n", 2, ctx); err != nil || !ok {
+		t.Fatalf("compare fail")
+	}
+	assign, _ := dict.GetAssignFunc("+=")
+	if err := dict.AssignWith("+=", assign, "$n", 2, ctx, "g", 0, ""); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$n"); fmt.Sprint(v) != "5" {
+		t.Fatalf("expect 5, got %v", v)
+	}
+	if s := collector.String(); s != "$n: 3 -> 5 [+=@g#0]\n" {
+		t.Fatalf("unexpected change log %q", s)
+	}
+	if err := dict.AssignWith("+=", nil, "$n", 2, ctx, "g", 0, ""); err == nil {
+		t.Fatalf("nil assign func should fail")
+	}
+
+	// 有id的节点，事件中包含id，错误被包装为NodeError
+	if err := dict.AssignWith("+=", assign, "$n", 1, ctx, "g", 1, "inc"); err != nil {
+		t.Fatal(err)
+	}
+	if s := collector.String(); s != "$n: 3 -> 6 [+=@g#0, +=@g#inc]\n" {
+		t.Fatalf("unexpected change log %q", s)
+	}
+	var nodeErr *NodeError
+	if err := dict.AssignWith("+=", assign, "$n", true, ctx, "g", 1, "inc"); !errors.As(err, &nodeErr) || nodeErr.NodeID != "inc" || nodeErr.Group != "g" {
+		t.Fatalf("expect NodeError, got %v", err)
+	}
+}
+
+func TestGoExportedName(t *testing.T) {
+	for k, v := range map[string]string{
+		"my_json_exp_group": "MyJsonExpGroup",
+		"filter":            "Filter",
+		"1st-rules":         "Group1stRules",
+		"__":                "Group",
+	} {
+		if ret := goExportedName(k); ret != v {
+			t.Fatalf("%s: expect %s, got %s", k, v, ret)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置源的格式
+type ConfigFormat uint
+
+const (
+	FormatJSON  ConfigFormat = iota // 标准json，同时也接受注释和尾随逗号
+	FormatJSONC                     // 带注释(//和/* */)以及尾随逗号的json
+	FormatYAML
+)
+
+func (m ConfigFormat) String() string {
+	switch m {
+	case FormatJSON:
+		return "json"
+	case FormatJSONC:
+		return "jsonc"
+	case FormatYAML:
+		return "yaml"
+	}
+	return "invalid"
+}
+
+func (m ConfigFormat) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *ConfigFormat) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "json":
+		*m = FormatJSON
+	case "jsonc":
+		*m = FormatJSONC
+	case "yaml", "yml":
+		*m = FormatYAML
+	default:
+		return fmt.Errorf("invalid format %s", text)
+	}
+	return nil
+}
+
+// 配置解析错误，Line和Column从1开始，为0时表示未知
+type ParseError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (m *ParseError) Error() string {
+	if m.Line <= 0 {
+		return m.Msg
+	}
+	if m.Column <= 0 {
+		return fmt.Sprintf("line %d: %s", m.Line, m.Msg)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", m.Line, m.Column, m.Msg)
+}
+
+// 将JSONC转换为标准json: 注释和尾随逗号替换为空格，保留换行，使错误位置与源文件一致
+func StripJSONC(source []byte) ([]byte, error) {
+	ret := make([]byte, len(source))
+	copy(ret, source)
+	lastComma := -1 // 最近一个尚未确定是否为尾随逗号的逗号位置
+	for i := 0; i < len(ret); i++ {
+		c := ret[i]
+		switch {
+		case c == '"':
+			lastComma = -1
+			i++
+			for ; i < len(ret) && ret[i] != '"'; i++ {
+				if ret[i] == '\\' {
+					i++
+				} else if ret[i] == '\n' {
+					break
+				}
+			}
+			if i >= len(ret) || ret[i] != '"' {
+				line, col := offsetToLineColumn(source, i)
+				return nil, &ParseError{Line: line, Column: col, Msg: "unterminated string"}
+			}
+		case c == '/' && i+1 < len(ret) && ret[i+1] == '/':
+			for ; i < len(ret) && ret[i] != '\n'; i++ {
+				ret[i] = ' '
+			}
+		case c == '/' && i+1 < len(ret) && ret[i+1] == '*':
+			start := i
+			ret[i], ret[i+1] = ' ', ' '
+			i += 2
+			for ; i < len(ret) && !(ret[i] == '*' && i+1 < len(ret) && ret[i+1] == '/'); i++ {
+				if ret[i] != '\n' && ret[i] != '\r' {
+					ret[i] = ' '
+				}
+			}
+			if i >= len(ret) {
+				line, col := offsetToLineColumn(source, start)
+				return nil, &ParseError{Line: line, Column: col, Msg: "unterminated comment"}
+			}
+			ret[i], ret[i+1] = ' ', ' '
+			i++
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				ret[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			lastComma = -1
+		}
+	}
+	return ret, nil
+}
+
+// 将字节偏移转换为行号和列号(从1开始)
+func offsetToLineColumn(source []byte, offset int) (int, int) {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := source[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := offset - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+func unmarshalJSONC(source []byte) (map[string]interface{}, error) {
+	stripped, err := StripJSONC(source)
+	if err != nil {
+		return nil, err
+	}
+	mp := make(map[string]interface{})
+	if err := json.Unmarshal(stripped, &mp); err != nil {
+		var offset int64 = -1
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			offset = e.Offset
+		case *json.UnmarshalTypeError:
+			offset = e.Offset
+		}
+		if offset >= 0 {
+			// Offset指向出错字符之后
+			if offset > 0 {
+				offset--
+			}
+			line, col := offsetToLineColumn(source, int(offset))
+			return nil, &ParseError{Line: line, Column: col, Msg: err.Error()}
+		}
+		return nil, &ParseError{Msg: err.Error()}
+	}
+	return mp, nil
+}
+
+var regExpYamlErrorLine = regexp.MustCompile(`line (\d+)(?:, column (\d+))?:\s*(.*)`)
+
+func unmarshalYAML(source []byte) (map[string]interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(source, &v); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		if matched := regExpYamlErrorLine.FindStringSubmatch(msg); len(matched) == 4 {
+			line, _ := strconv.Atoi(matched[1])
+			col, _ := strconv.Atoi(matched[2])
+			return nil, &ParseError{Line: line, Column: col, Msg: matched[3]}
+		}
+		return nil, &ParseError{Msg: msg}
+	}
+	if v == nil {
+		return nil, &ParseError{Msg: "empty yaml document"}
+	}
+	// 经过json转换，使数值、对象等的类型与json配置完全一致
+	normalized, err := normalizeYAMLValue(v)
+	if err != nil {
+		return nil, &ParseError{Msg: err.Error()}
+	}
+	bts, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, &ParseError{Msg: err.Error()}
+	}
+	mp := make(map[string]interface{})
+	if err := json.Unmarshal(bts, &mp); err != nil {
+		return nil, &ParseError{Msg: "yaml document is not a mapping"}
+	}
+	return mp, nil
+}
+
+// yaml中非字符串的键转换为字符串
+func normalizeYAMLValue(v interface{}) (interface{}, error) {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		for k, item := range tv {
+			n, err := normalizeYAMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			tv[k] = n
+		}
+		return tv, nil
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(tv))
+		for k, item := range tv {
+			n, err := normalizeYAMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret[fmt.Sprintf("%v", k)] = n
+		}
+		return ret, nil
+	case []interface{}:
+		for i, item := range tv {
+			n, err := normalizeYAMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			tv[i] = n
+		}
+		return tv, nil
+	default:
+		return v, nil
+	}
+}
+
+// 按指定格式创建Configuration对象
+func NewConfigurationWithFormat(source []byte, format ConfigFormat, dict *Dictionary) (*Configuration, error) {
+	if len(source) == 0 || dict == nil {
+		return nil, fmt.Errorf("invalid source or dict")
+	}
+	var mp map[string]interface{}
+	var err error
+	switch format {
+	case FormatJSON, FormatJSONC:
+		mp, err = unmarshalJSONC(source)
+	case FormatYAML:
+		mp, err = unmarshalYAML(source)
+	default:
+		return nil, fmt.Errorf("invalid format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newConfigurationFromMap(source, mp, dict)
+}
+
+// 从yaml创建Configuration对象
+func NewYamlConfiguration(yamlSource []byte, dict *Dictionary) (*Configuration, error) {
+	return NewConfigurationWithFormat(yamlSource, FormatYAML, dict)
+}
+
+// 根据扩展名判断配置文件格式: .yaml/.yml为yaml，其他为json(可以带注释)
+func ConfigFormatOfFile(fileName string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".jsonc":
+		return FormatJSONC
+	default:
+		return FormatJSON
+	}
+}
+
+// 从文件加载Configuration对象，错误信息中包含文件名和行列号
+func LoadConfigurationFile(fileName string, dict *Dictionary) (*Configuration, error) {
+	source, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := NewConfigurationWithFormat(source, ConfigFormatOfFile(fileName), dict)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return ret, nil
+}
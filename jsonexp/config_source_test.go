@@ -0,0 +1,105 @@
+package jsonexp
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var jsoncSource = `{
+	// name values
+	"name1": "value1", /* trailing block comment */
+	"name2": 1234,
+	"url": "http://example.com/a//b", // "//" in string is not a comment
+	"my_json_exp_group": [
+		[
+			["$rand", ">", 5],
+			["$my_var", "=", "hello world"], // trailing comma
+		],
+	],
+}`
+
+var yamlSource = `
+name1: value1
+name2: 1234
+url: http://example.com/a//b
+my_json_exp_group:
+  - - ["$rand", ">", 5]
+    - ["$my_var", "=", "hello world"]
+`
+
+func TestConfigurationSources(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$my_var", nil)
+	jsonc, err := NewConfiguration([]byte(jsoncSource), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	yml, err := NewYamlConfiguration([]byte(yamlSource), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(jsonc.nameValues, yml.nameValues) {
+		t.Fatalf("name values differ: %v, %v", jsonc.nameValues, yml.nameValues)
+	}
+	if v, _ := yml.GetNameValue("name2", nil); v != float64(1234) {
+		t.Fatalf("yaml number should be float64 as json, %#v", v)
+	}
+	if v, _ := jsonc.GetNameValue("url", nil); v != "http://example.com/a//b" {
+		t.Fatalf("url = %v", v)
+	}
+	g1, ok1 := jsonc.GetJsonExpGroup("my_json_exp_group")
+	g2, ok2 := yml.GetJsonExpGroup("my_json_exp_group")
+	if !ok1 || !ok2 || !reflect.DeepEqual(g1.groupSource, g2.groupSource) {
+		t.Fatalf("groups differ")
+	}
+}
+
+func TestConfigurationParseError(t *testing.T) {
+	dict := NewDictionary()
+	_, err := NewConfigurationWithFormat([]byte("{\n\t\"a\": 1,\n\t\"b\": x\n}"), FormatJSONC, dict)
+	if pe, ok := err.(*ParseError); !ok || pe.Line != 3 || pe.Column != 7 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = NewConfigurationWithFormat([]byte("{\n/* unterminated\n"), FormatJSONC, dict)
+	if pe, ok := err.(*ParseError); !ok || pe.Line != 2 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = NewYamlConfiguration([]byte("a: 1\nb: [1, 2\nc: 3\n"), dict)
+	if pe, ok := err.(*ParseError); !ok || pe.Line == 0 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// NewConfiguration和LoadConfigurationFile包装的错误中仍然可以取得*ParseError
+func TestParseErrorWrapped(t *testing.T) {
+	dict := NewDictionary()
+	source := []byte("{\n\t\"a\": 1,\n\t\"b\": x\n}")
+	_, err := NewConfiguration(source, dict)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 3 || pe.Column != 7 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "unmarshal json fail, ") {
+		t.Fatalf("unexpected message: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "jsonexp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(fileName, source, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfigurationFile(fileName, dict)
+	pe = nil
+	if !errors.As(err, &pe) || pe.Line != 3 || !strings.HasPrefix(err.Error(), fileName+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+// 频次计数:
+//
+//	$key|freq:1h        (或$key|freq(1h)) 键为$key的值的计数器在最近1小时内的计数
+//	["$key", "incr", "1h"]  键为$key的值的计数器加1
+//
+// 窗口的格式为[fixed:|sliding:]duration，duration支持time.ParseDuration的格式以及天(d)，如30m、1h、7d。
+// 默认为滑动窗口，统计最近duration内的计数; fixed为固定窗口，统计当前自然周期(按duration对齐)内的计数。
+// 读取和增加计数必须使用相同的窗口。需要由多个变量组成键时，先将宏赋值给一个变量:
+//
+//	[["$freq_key", "=", "{{$user_id}}:{{$ad_id}}"]],
+//	[["$freq_key|freq(1h)", "<", 3], [["$show", "=", 1], ["$freq_key", "incr", "1h"]]]
+const (
+	PipelineFnFreq = "freq"
+	AssignIncr     = "incr"
+)
+
+const (
+	defaultCounterMaxKeys = 100000
+	defaultCounterSlots   = 10
+	maxCounterPersistTime = time.Hour * 24 * 7 // goutil.FileMap支持的最长存活时间
+)
+
+type counterWindow struct {
+	duration time.Duration
+	fixed    bool
+}
+
+func (m counterWindow) String() string {
+	if m.fixed {
+		return "fixed:" + m.duration.String()
+	}
+	return m.duration.String()
+}
+
+func parseCounterWindow(spec string) (counterWindow, error) {
+	var ret counterWindow
+	s := strings.TrimSpace(spec)
+	if strings.HasPrefix(s, "fixed:") {
+		ret.fixed = true
+		s = s[len("fixed:"):]
+	} else if strings.HasPrefix(s, "sliding:") {
+		s = s[len("sliding:"):]
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return ret, fmt.Errorf("invalid counter window %s", spec)
+		}
+		ret.duration = time.Duration(days * float64(time.Hour*24))
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return ret, fmt.Errorf("invalid counter window %s", spec)
+		}
+		ret.duration = d
+	}
+	if ret.duration <= 0 {
+		return ret, fmt.Errorf("invalid counter window %s", spec)
+	}
+	return ret, nil
+}
+
+// 计数槽，index为槽的绝对序号(时间/槽宽度)
+type counterSlot struct {
+	Index int64 `json:"i"`
+	Count int64 `json:"c"`
+}
+
+type counterEntry struct {
+	key   string
+	slots []counterSlot
+	elem  *list.Element
+}
+
+// 频次计数器的存储选项
+type CounterStoreOptions struct {
+	MaxKeys int             // 最多保存的计数器数量，超出时淘汰最久未使用的计数器，<=0时为100000
+	Slots   int             // 滑动窗口划分的槽数，越大越精确，占用内存越多，<=0时为10
+	FileMap *goutil.FileMap // 可选，用于持久化计数，进程重启后计数不丢失
+}
+
+// 进程内的频次计数器存储，计数器按窗口过期，数量有上限
+type CounterStore struct {
+	sync.Mutex
+	opts    CounterStoreOptions
+	entries map[string]*counterEntry
+	lru     *list.List
+}
+
+func NewCounterStore(opts CounterStoreOptions) *CounterStore {
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = defaultCounterMaxKeys
+	}
+	if opts.Slots <= 0 {
+		opts.Slots = defaultCounterSlots
+	}
+	return &CounterStore{opts: opts, entries: make(map[string]*counterEntry), lru: list.New()}
+}
+
+func (m *CounterStore) slotCount(w counterWindow) int {
+	if w.fixed {
+		return 1
+	}
+	return m.opts.Slots
+}
+
+func (m *CounterStore) slotWidth(w counterWindow) int64 {
+	ret := int64(w.duration) / int64(m.slotCount(w))
+	if ret <= 0 {
+		ret = 1
+	}
+	return ret
+}
+
+// 当前时间所在的槽的绝对序号
+func (m *CounterStore) slotIndex(w counterWindow, now time.Time) int64 {
+	return now.UnixNano() / m.slotWidth(w)
+}
+
+func (m *CounterStore) sum(entry *counterEntry, w counterWindow, now time.Time) int64 {
+	current := m.slotIndex(w, now)
+	oldest := current - int64(m.slotCount(w)) + 1
+	var ret int64
+	for _, slot := range entry.slots {
+		if slot.Index >= oldest && slot.Index <= current {
+			ret += slot.Count
+		}
+	}
+	return ret
+}
+
+// 获取计数器，不存在时从持久化存储中加载
+func (m *CounterStore) get(storeKey string) *counterEntry {
+	if entry, ok := m.entries[storeKey]; ok {
+		m.lru.MoveToFront(entry.elem)
+		return entry
+	}
+	if m.opts.FileMap == nil {
+		return nil
+	}
+	data := m.opts.FileMap.Get(storeKey)
+	if len(data) == 0 {
+		return nil
+	}
+	entry := &counterEntry{key: storeKey}
+	if err := json.Unmarshal(data, &entry.slots); err != nil {
+		return nil
+	}
+	m.add(entry)
+	return entry
+}
+
+func (m *CounterStore) add(entry *counterEntry) {
+	entry.elem = m.lru.PushFront(entry)
+	m.entries[entry.key] = entry
+	for len(m.entries) > m.opts.MaxKeys {
+		m.remove(m.lru.Back().Value.(*counterEntry), false)
+	}
+}
+
+// persist为true时同时从持久化存储中删除
+func (m *CounterStore) remove(entry *counterEntry, persist bool) {
+	m.lru.Remove(entry.elem)
+	delete(m.entries, entry.key)
+	if persist && m.opts.FileMap != nil {
+		m.opts.FileMap.Delete(entry.key)
+	}
+}
+
+func (m *CounterStore) persist(entry *counterEntry, w counterWindow, now time.Time) {
+	if m.opts.FileMap == nil {
+		return
+	}
+	data, err := json.Marshal(entry.slots)
+	if err != nil {
+		return
+	}
+	live := time.Duration((m.slotIndex(w, now)+int64(m.slotCount(w)))*m.slotWidth(w) - now.UnixNano())
+	if live > maxCounterPersistTime {
+		live = maxCounterPersistTime
+	}
+	if live > 0 {
+		m.opts.FileMap.Put(entry.key, data, true, live)
+	}
+}
+
+func counterStoreKey(key string, w counterWindow) string {
+	return w.String() + "|" + key
+}
+
+// 计数器加delta，返回窗口内的计数
+func (m *CounterStore) Incr(key string, window string, delta int64, now time.Time) (int64, error) {
+	w, err := parseCounterWindow(window)
+	if err != nil {
+		return 0, err
+	}
+	if key == "" {
+		return 0, fmt.Errorf("counter key is empty")
+	}
+	storeKey := counterStoreKey(key, w)
+	m.Lock()
+	defer m.Unlock()
+	entry := m.get(storeKey)
+	if entry == nil {
+		entry = &counterEntry{key: storeKey}
+		m.add(entry)
+	}
+	current := m.slotIndex(w, now)
+	oldest := current - int64(m.slotCount(w)) + 1
+	// 丢弃已经移出窗口的槽
+	slots := entry.slots[:0]
+	for _, slot := range entry.slots {
+		if slot.Index >= oldest {
+			slots = append(slots, slot)
+		}
+	}
+	entry.slots = slots
+	if n := len(entry.slots); n > 0 && entry.slots[n-1].Index == current {
+		entry.slots[n-1].Count += delta
+	} else {
+		entry.slots = append(entry.slots, counterSlot{Index: current, Count: delta})
+	}
+	m.persist(entry, w, now)
+	return m.sum(entry, w, now), nil
+}
+
+// 撤销now时的一次Incr，计数所在的槽已经移出窗口时忽略
+func (m *CounterStore) undoIncr(key string, window string, delta int64, now time.Time) {
+	w, err := parseCounterWindow(window)
+	if err != nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	entry := m.get(counterStoreKey(key, w))
+	if entry == nil {
+		return
+	}
+	index := m.slotIndex(w, now)
+	for i := range entry.slots {
+		if entry.slots[i].Index == index {
+			entry.slots[i].Count -= delta
+			m.persist(entry, w, now)
+			return
+		}
+	}
+}
+
+// 窗口内的计数
+func (m *CounterStore) Count(key string, window string, now time.Time) (int64, error) {
+	w, err := parseCounterWindow(window)
+	if err != nil {
+		return 0, err
+	}
+	m.Lock()
+	defer m.Unlock()
+	entry := m.get(counterStoreKey(key, w))
+	if entry == nil {
+		return 0, nil
+	}
+	ret := m.sum(entry, w, now)
+	if ret == 0 && m.expired(entry, w, now) {
+		m.remove(entry, true)
+	}
+	return ret, nil
+}
+
+func (m *CounterStore) expired(entry *counterEntry, w counterWindow, now time.Time) bool {
+	oldest := m.slotIndex(w, now) - int64(m.slotCount(w)) + 1
+	for _, slot := range entry.slots {
+		if slot.Index >= oldest {
+			return false
+		}
+	}
+	return true
+}
+
+// 删除计数器
+func (m *CounterStore) Reset(key string, window string) error {
+	w, err := parseCounterWindow(window)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if entry := m.get(counterStoreKey(key, w)); entry != nil {
+		m.remove(entry, true)
+	}
+	return nil
+}
+
+// 删除所有已过期的计数器，返回删除的数量
+func (m *CounterStore) RemoveExpired(now time.Time) int {
+	m.Lock()
+	defer m.Unlock()
+	ret := 0
+	for k, entry := range m.entries {
+		i := strings.Index(k, "|")
+		w, err := parseCounterWindow(k[:i])
+		if err != nil || m.expired(entry, w, now) {
+			m.remove(entry, true)
+			ret++
+		}
+	}
+	return ret
+}
+
+// 内存中的计数器数量
+func (m *CounterStore) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.entries)
+}
+
+// 设置字典使用的频次计数器存储，NewDictionary默认使用一个不持久化的存储
+func (m *Dictionary) SetCounterStore(store *CounterStore) {
+	m.mustNotFrozen("SetCounterStore")
+	if store == nil {
+		return
+	}
+	m.counterStoreLock.Lock()
+	defer m.counterStoreLock.Unlock()
+	m.counterStore = store
+	m.changed()
+}
+
+func (m *Dictionary) CounterStore() *CounterStore {
+	defer m.rlock(&m.counterStoreLock)()
+	return m.counterStore
+}
+
+func counterKey(v interface{}) (string, error) {
+	if v == nil {
+		return "", fmt.Errorf("counter key is nil")
+	}
+	ret := listItemKey(v)
+	if ret == "" {
+		return "", fmt.Errorf("counter key is empty")
+	}
+	return ret, nil
+}
+
+// freq:window 以输入值为键的计数器在窗口内的计数
+func (m *Dictionary) pipeFnFreq(input interface{}, arg string, context Context) (interface{}, error) {
+	key, err := counterKey(input)
+	if err != nil {
+		return nil, err
+	}
+	return m.CounterStore().Count(key, arg, m.Now())
+}
+
+// incr 以左值为键的计数器加1，右值为窗口
+func (m *Dictionary) incrAssign(L string, lValue interface{}, R interface{}, ret Context) error {
+	key, err := counterKey(lValue)
+	if err != nil {
+		return err
+	}
+	window, ok := R.(string)
+	if !ok {
+		return fmt.Errorf("invalid counter window %v", R)
+	}
+	store, now := m.CounterStore(), m.Now()
+	if _, err = store.Incr(key, window, 1, now); err != nil {
+		return err
+	}
+	// 在事务上下文中(如配置管理接口的dry run)回滚时撤销计数
+	if tx, ok := ret.(*TxContext); ok {
+		tx.RecordUndo(func() {
+			store.undoIncr(key, window, 1, now)
+		})
+	}
+	return nil
+}
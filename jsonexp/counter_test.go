@@ -0,0 +1,136 @@
+package jsonexp
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestCounterStoreWindows(t *testing.T) {
+	store := NewCounterStore(CounterStoreOptions{Slots: 6})
+	base := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		store.Incr("u1", "1h", 1, base.Add(time.Duration(i)*20*time.Minute))
+	}
+	// 10:00, 10:20, 10:40各一次，10:50时滑动窗口内3次，11:05时10:00的计数移出窗口
+	if n, _ := store.Count("u1", "1h", base.Add(50*time.Minute)); n != 3 {
+		t.Fatalf("expect 3, got %d", n)
+	}
+	if n, _ := store.Count("u1", "1h", base.Add(65*time.Minute)); n != 2 {
+		t.Fatalf("expect 2, got %d", n)
+	}
+	if n, _ := store.Count("u1", "1h", base.Add(3*time.Hour)); n != 0 || store.Len() != 0 {
+		t.Fatalf("expect expired, got %d, len %d", n, store.Len())
+	}
+
+	store.Incr("u2", "fixed:1h", 1, base.Add(50*time.Minute))
+	store.Incr("u2", "fixed:1h", 1, base.Add(55*time.Minute))
+	if n, _ := store.Count("u2", "fixed:1h", base.Add(59*time.Minute)); n != 2 {
+		t.Fatalf("expect 2, got %d", n)
+	}
+	if n, _ := store.Count("u2", "fixed:1h", base.Add(61*time.Minute)); n != 0 {
+		t.Fatalf("fixed window should be reset, got %d", n)
+	}
+	if _, err := store.Incr("u2", "1x", 1, base); err == nil {
+		t.Fatalf("invalid window should fail")
+	}
+	if n, _ := store.Incr("u3", "1d", 1, base); n != 1 {
+		t.Fatalf("expect 1, got %d", n)
+	}
+}
+
+func TestCounterStoreMaxKeys(t *testing.T) {
+	store := NewCounterStore(CounterStoreOptions{MaxKeys: 2})
+	now := time.Now()
+	store.Incr("a", "1h", 1, now)
+	store.Incr("b", "1h", 1, now)
+	store.Count("a", "1h", now)
+	store.Incr("c", "1h", 1, now)
+	if store.Len() != 2 {
+		t.Fatalf("expect 2 keys, got %d", store.Len())
+	}
+	// b最久未使用，被淘汰
+	if n, _ := store.Count("b", "1h", now); n != 0 {
+		t.Fatalf("b should be evicted")
+	}
+	if n, _ := store.Count("a", "1h", now); n != 1 {
+		t.Fatalf("a should be kept")
+	}
+}
+
+func TestCounterStorePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonexp_counter")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	fm, err := goutil.NewFileMap("counter", dir, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	now := time.Now()
+	store := NewCounterStore(CounterStoreOptions{FileMap: fm})
+	store.Incr("u1", "1h", 1, now)
+	store.Incr("u1", "1h", 1, now)
+	fm.Flush()
+
+	// 新的存储从持久化数据中加载计数
+	store2 := NewCounterStore(CounterStoreOptions{FileMap: fm})
+	if n, _ := store2.Count("u1", "1h", now); n != 2 {
+		t.Fatalf("expect 2, got %d", n)
+	}
+	store2.Reset("u1", "1h")
+	if n, _ := NewCounterStore(CounterStoreOptions{FileMap: fm}).Count("u1", "1h", now); n != 0 {
+		t.Fatalf("expect 0 after reset, got %d", n)
+	}
+	fm.Close()
+}
+
+func TestFrequencyCapping(t *testing.T) {
+	dict := NewDictionary()
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	dict.SetClock(func() time.Time { return now })
+	dict.RegisterVar("$user_id", nil)
+	dict.RegisterVar("$ad_id", nil)
+	dict.RegisterVar("$freq_key", nil)
+	dict.RegisterVar("$show", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"cap": [
+			[["$freq_key", "=", "{{$user_id}}:{{$ad_id}}"]],
+			[["$show", "=", 0]],
+			[["$freq_key|freq(1h)", "<", 3], [["$show", "=", 1], ["$freq_key", "incr", "1h"]]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("cap")
+	run := func(user string) interface{} {
+		ctx := &goutil.DefaultContext{}
+		ctx.SetCtxData("$user_id", user)
+		ctx.SetCtxData("$ad_id", "ad1")
+		if err := g.Execute(ctx); err != nil {
+			t.Fatalf(err.Error())
+		}
+		ret, _ := ctx.GetCtxData("$show")
+		return ret
+	}
+	for i := 0; i < 3; i++ {
+		if v := run("u1"); v != float64(1) {
+			t.Fatalf("show %d: expect 1, got %v", i, v)
+		}
+	}
+	if v := run("u1"); v != float64(0) {
+		t.Fatalf("capped: expect 0, got %v", v)
+	}
+	if v := run("u2"); v != float64(1) {
+		t.Fatalf("other user: expect 1, got %v", v)
+	}
+	now = now.Add(61 * time.Minute)
+	if v := run("u1"); v != float64(1) {
+		t.Fatalf("next hour: expect 1, got %v", v)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日期时间比较运算符支持的格式，按顺序尝试解析
+var DateTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"20060102150405",
+	"20060102",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// 解析相对时间，格式为: now[(+|-)n(s|m|h|d|w)] 或 today[(+|-)n(s|m|h|d|w)]
+// 例如: now-7d, now+30m, today-1d
+func parseRelativeDateTime(s string, now time.Time) (time.Time, bool) {
+	var base time.Time
+	var rest string
+	if strings.HasPrefix(s, "now") {
+		base = now
+		rest = s[len("now"):]
+	} else if strings.HasPrefix(s, "today") {
+		y, mon, d := now.Date()
+		base = time.Date(y, mon, d, 0, 0, 0, 0, now.Location())
+		rest = s[len("today"):]
+	} else {
+		return time.Time{}, false
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return base, true
+	}
+	sign := rest[0]
+	if sign != '+' && sign != '-' {
+		return time.Time{}, false
+	}
+	rest = strings.TrimSpace(rest[1:])
+	if len(rest) < 2 {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(rest[:len(rest)-1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var unit time.Duration
+	switch rest[len(rest)-1] {
+	case 's':
+		unit = time.Second
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = time.Hour * 24
+	case 'w':
+		unit = time.Hour * 24 * 7
+	default:
+		return time.Time{}, false
+	}
+	if sign == '-' {
+		n = -n
+	}
+	return base.Add(time.Duration(n) * unit), true
+}
+
+// 将v转换为时间。支持DateTimeLayouts中的格式、相对时间表达式、以及unix时间戳(秒或毫秒)
+func GetDateTimeValue(v interface{}, now time.Time) (time.Time, bool) {
+	switch tv := v.(type) {
+	case time.Time:
+		return tv, true
+	case *time.Time:
+		if tv == nil {
+			return time.Time{}, false
+		}
+		return *tv, true
+	}
+	switch GetValueType(v) {
+	case VarInt, VarFloat:
+		ts, _ := GetIntValue(v)
+		return unixToTime(ts), true
+	case VarStr:
+		s := strings.TrimSpace(v.(string))
+		if s == "" {
+			return time.Time{}, false
+		}
+		if ret, ok := parseRelativeDateTime(s, now); ok {
+			return ret, true
+		}
+		for _, layout := range DateTimeLayouts {
+			if ret, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+				return ret, true
+			}
+		}
+		if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return unixToTime(ts), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 大于1e12的时间戳视为毫秒
+func unixToTime(ts int64) time.Time {
+	if ts > 1e12 || ts < -1e12 {
+		return time.Unix(0, ts*int64(time.Millisecond))
+	}
+	return time.Unix(ts, 0)
+}
+
+func compareDateTime(L, R interface{}, now time.Time) (int, error) {
+	l, ok := GetDateTimeValue(L, now)
+	if !ok {
+		return 0, fmt.Errorf("invalid param L, not a datetime")
+	}
+	r, ok := GetDateTimeValue(R, now)
+	if !ok {
+		return 0, fmt.Errorf("right value not datetime-incompatible")
+	}
+	if l.Before(r) {
+		return -1, nil
+	} else if l.After(r) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// 创建一个使用字典时钟的日期时间比较运算符
+func (m *Dictionary) dateTimeCompare(match func(ret int) bool) CompareFunc {
+	return func(L, R interface{}, context Context) (bool, error) {
+		ret, err := compareDateTime(L, R, m.Now())
+		if err != nil {
+			return false, err
+		}
+		return match(ret), nil
+	}
+}
+
+func (m *Dictionary) dateTimeBetween(not bool) CompareFunc {
+	return func(L, R interface{}, context Context) (bool, error) {
+		b, e, ok := splitBetweenBounds(R)
+		if !ok {
+			return false, fmt.Errorf("right value not a between string")
+		}
+		now := m.Now()
+		retB, err := compareDateTime(L, b, now)
+		if err != nil {
+			return false, err
+		}
+		retE, err := compareDateTime(L, e, now)
+		if err != nil {
+			return false, err
+		}
+		return (retB >= 0 && retE <= 0) != not, nil
+	}
+}
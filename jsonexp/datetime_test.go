@@ -0,0 +1,68 @@
+package jsonexp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestDateTimeCompare(t *testing.T) {
+	dict := NewDictionary()
+	now := time.Date(2021, 11, 23, 10, 30, 0, 0, time.Local)
+	dict.SetClock(func() time.Time { return now })
+	dict.RegisterVar("$reg_time", nil)
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$reg_time", "2021/11/20 08:00:00")
+
+	cases := []struct {
+		op    string
+		right interface{}
+		want  bool
+	}{
+		{"dt>", "now-7d", true},
+		{"dt<", "now-2d", true},
+		{"dt>=", "2021-11-20T08:00:00", true},
+		{"dt=", "2021-11-20 08:00:00", true},
+		{"dt!=", "2021-11-20", true},
+		{"dt<", "today", true},
+		{"dtbetween", "now-1w,now-3d", true},
+		{"dtbetween", []interface{}{"2021-11-21", "now"}, false},
+		{"^dtbetween", "2021-11-21,now", true},
+		{"dt>", now.Add(-time.Hour * 24 * 30).Unix(), true},
+	}
+	for _, c := range cases {
+		ret, err := dict.Compare(c.op, "$reg_time", c.right, ctx)
+		if err != nil {
+			t.Fatalf("%s %v: %s", c.op, c.right, err.Error())
+		}
+		if ret != c.want {
+			t.Fatalf("%s %v, expect %v", c.op, c.right, c.want)
+		}
+	}
+
+	if _, err := dict.Compare("dt>", "$reg_time", "now-7x", ctx); err == nil {
+		t.Fatalf("expect error for invalid relative time")
+	}
+}
+
+// 执行期间修改时钟，配合-race检查
+func TestSetClockConcurrent(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$reg_time", nil)
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$reg_time", "2021/11/20 08:00:00")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			dict.Compare("dt<", "$reg_time", "now", ctx)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		dict.SetClock(func() time.Time { return time.Date(2021, 11, 23, 10, 30, 0, 0, time.Local) })
+	}
+	wg.Wait()
+}
@@ -0,0 +1,465 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 键值和表达式组的变化
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// 表达式组中节点的变化
+const (
+	NodeInserted = "inserted"
+	NodeDeleted  = "deleted"
+	NodeMoved    = "moved"   // 节点内容不变，位置发生了变化
+	NodeChanged  = "changed" // 条件相同而赋值不同，或者赋值相同而条件不同，或者属性不同
+)
+
+// 两个配置之间的差异
+type ConfigDiff struct {
+	NameValues []*NameValueDiff `json:"name_values"`
+	Vars       []*NameValueDiff `json:"vars"`    // 变量声明的变化，Old和New为声明的对象形式
+	Buckets    []*NameValueDiff `json:"buckets"` // 令牌桶声明的变化，Old和New为声明的对象形式
+	Groups     []*GroupDiff     `json:"groups"`
+}
+
+type NameValueDiff struct {
+	Name string      `json:"name"`
+	Kind string      `json:"kind"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+type GroupDiff struct {
+	Name      string      `json:"name"`
+	Kind      string      `json:"kind"`
+	NodeCount int         `json:"node_count,omitempty"` // 新增或删除的表达式组的节点数
+	Nodes     []*NodeDiff `json:"nodes,omitempty"`
+}
+
+// 节点的变化，OldIndex和NewIndex为节点在原来和新的表达式组中的位置，不存在时为-1
+type NodeDiff struct {
+	Kind        string        `json:"kind"`
+	OldIndex    int           `json:"old_index"`
+	NewIndex    int           `json:"new_index"`
+	ID          string        `json:"id,omitempty"`
+	Node        []interface{} `json:"node,omitempty"`
+	Conditions  []*ExpDiff    `json:"conditions,omitempty"`
+	Assignments []*ExpDiff    `json:"assignments,omitempty"`
+	Meta        []*MetaDiff   `json:"meta,omitempty"`
+}
+
+// 节点属性(enabled、start等)的变化
+type MetaDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// 节点中新增(added)或删除(removed)的比较、赋值表达式
+type ExpDiff struct {
+	Kind string        `json:"kind"`
+	Exp  []interface{} `json:"exp"`
+}
+
+// 比较两个配置的键值、变量和令牌桶的声明以及表达式组。
+// 表达式组中的节点以id(没有id时以条件，也没有条件时以赋值)为标识按顺序对齐，对齐的节点内容不同时为changed;
+// 其余节点中内容相同的为moved，条件或赋值其中之一相同的为changed，剩下的为inserted或deleted
+func DiffConfigurations(oldCfg, newCfg *Configuration) *ConfigDiff {
+	ret := &ConfigDiff{
+		NameValues: diffNameValues(oldCfg.nameValues, newCfg.nameValues),
+		Vars:       diffNameValues(varDeclSources(oldCfg), varDeclSources(newCfg)),
+		Buckets:    diffNameValues(bucketDeclSources(oldCfg), bucketDeclSources(newCfg)),
+		Groups:     []*GroupDiff{},
+	}
+	groupNames := make(map[string]struct{})
+	for _, name := range oldCfg.ListJsonExpGroups() {
+		groupNames[name] = struct{}{}
+	}
+	for _, name := range newCfg.ListJsonExpGroups() {
+		groupNames[name] = struct{}{}
+	}
+	names := make([]string, 0, len(groupNames))
+	for name := range groupNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oldGroup, inOld := oldCfg.jsonExpGroups[name]
+		newGroup, inNew := newCfg.jsonExpGroups[name]
+		switch {
+		case !inOld:
+			ret.Groups = append(ret.Groups, &GroupDiff{Name: name, Kind: DiffAdded, NodeCount: len(newGroup.group)})
+		case !inNew:
+			ret.Groups = append(ret.Groups, &GroupDiff{Name: name, Kind: DiffRemoved, NodeCount: len(oldGroup.group)})
+		default:
+			if nodes := diffGroupNodes(oldGroup.group, newGroup.group); len(nodes) > 0 {
+				ret.Groups = append(ret.Groups, &GroupDiff{Name: name, Kind: DiffChanged, Nodes: nodes})
+			}
+		}
+	}
+	return ret
+}
+
+func diffNameValues(oldValues, newValues map[string]interface{}) []*NameValueDiff {
+	ret := []*NameValueDiff{}
+	for _, name := range unionKeys(oldValues, newValues) {
+		oldValue, inOld := oldValues[name]
+		newValue, inNew := newValues[name]
+		switch {
+		case !inOld:
+			ret = append(ret, &NameValueDiff{Name: name, Kind: DiffAdded, New: newValue})
+		case !inNew:
+			ret = append(ret, &NameValueDiff{Name: name, Kind: DiffRemoved, Old: oldValue})
+		case compactJSON(oldValue) != compactJSON(newValue):
+			ret = append(ret, &NameValueDiff{Name: name, Kind: DiffChanged, Old: oldValue, New: newValue})
+		}
+	}
+	return ret
+}
+
+// 变量声明的对象形式，与配置中vars的写法一致
+func varDeclSources(cfg *Configuration) map[string]interface{} {
+	ret := make(map[string]interface{}, len(cfg.varDecls))
+	for name, decl := range cfg.varDecls {
+		src := map[string]interface{}{"type": decl.Type.String()}
+		if decl.HasDefault {
+			src["default"] = decl.Default
+		}
+		ret[name] = src
+	}
+	return ret
+}
+
+// 令牌桶声明的对象形式，与配置中buckets的写法一致
+func bucketDeclSources(cfg *Configuration) map[string]interface{} {
+	ret := make(map[string]interface{})
+	if cfg.limiters == nil {
+		return ret
+	}
+	for name, limiter := range cfg.limiters.limiters {
+		src := map[string]interface{}{"capacity": limiter.decl.Capacity, "qps": limiter.decl.Qps}
+		if limiter.decl.PerKey {
+			src["per_key"] = true
+			src["max_keys"] = limiter.decl.MaxKeys
+		}
+		ret[name] = src
+	}
+	return ret
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	ret := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		ret = append(ret, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// 没有任何差异
+func (m *ConfigDiff) Empty() bool {
+	return len(m.NameValues) == 0 && len(m.Vars) == 0 && len(m.Buckets) == 0 && len(m.Groups) == 0
+}
+
+// 文本格式的差异，+为新增，-为删除，~为修改，>为移动
+func (m *ConfigDiff) String() string {
+	var sb strings.Builder
+	writeNameValues(&sb, "", m.NameValues)
+	writeNameValues(&sb, "var ", m.Vars)
+	writeNameValues(&sb, "bucket ", m.Buckets)
+	for _, g := range m.Groups {
+		switch g.Kind {
+		case DiffAdded:
+			fmt.Fprintf(&sb, "+ group %s (%d nodes)\n", g.Name, g.NodeCount)
+		case DiffRemoved:
+			fmt.Fprintf(&sb, "- group %s (%d nodes)\n", g.Name, g.NodeCount)
+		default:
+			fmt.Fprintf(&sb, "~ group %s\n", g.Name)
+		}
+		for _, n := range g.Nodes {
+			id := ""
+			if n.ID != "" {
+				id = " (" + n.ID + ")"
+			}
+			switch n.Kind {
+			case NodeInserted:
+				fmt.Fprintf(&sb, "    + node %d%s: %s\n", n.NewIndex, id, compactJSON(n.Node))
+			case NodeDeleted:
+				fmt.Fprintf(&sb, "    - node %d%s: %s\n", n.OldIndex, id, compactJSON(n.Node))
+			case NodeMoved:
+				fmt.Fprintf(&sb, "    > node %d -> %d%s: %s\n", n.OldIndex, n.NewIndex, id, compactJSON(n.Node))
+			default:
+				fmt.Fprintf(&sb, "    ~ node %d -> %d%s\n", n.OldIndex, n.NewIndex, id)
+				for _, e := range n.Meta {
+					fmt.Fprintf(&sb, "        ~ %s: %s -> %s\n", e.Field, compactJSON(e.Old), compactJSON(e.New))
+				}
+				for _, e := range n.Conditions {
+					fmt.Fprintf(&sb, "        %s condition %s\n", expDiffSign(e.Kind), compactJSON(e.Exp))
+				}
+				for _, e := range n.Assignments {
+					fmt.Fprintf(&sb, "        %s assignment %s\n", expDiffSign(e.Kind), compactJSON(e.Exp))
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+func writeNameValues(sb *strings.Builder, prefix string, list []*NameValueDiff) {
+	for _, v := range list {
+		switch v.Kind {
+		case DiffAdded:
+			fmt.Fprintf(sb, "+ %s%s: %s\n", prefix, v.Name, compactJSON(v.New))
+		case DiffRemoved:
+			fmt.Fprintf(sb, "- %s%s: %s\n", prefix, v.Name, compactJSON(v.Old))
+		default:
+			fmt.Fprintf(sb, "~ %s%s: %s -> %s\n", prefix, v.Name, compactJSON(v.Old), compactJSON(v.New))
+		}
+	}
+}
+
+func expDiffSign(kind string) string {
+	if kind == DiffAdded {
+		return "+"
+	}
+	return "-"
+}
+
+func conditionSources(exp *JsonExp) []interface{} {
+	ret := make([]interface{}, len(exp.compareExpList))
+	for i, v := range exp.compareExpList {
+		ret[i] = []interface{}{v.Left, v.CompareName, v.Right}
+	}
+	return ret
+}
+
+func assignmentSources(exp *JsonExp) []interface{} {
+	ret := make([]interface{}, len(exp.assignExpList))
+	for i, v := range exp.assignExpList {
+		ret[i] = []interface{}{v.Left, v.AssignName, v.Right}
+	}
+	return ret
+}
+
+// 节点的数组形式: 比较表达式，最后是一个赋值表达式或者赋值表达式的数组
+func nodeSource(exp *JsonExp) []interface{} {
+	ret := conditionSources(exp)
+	assigns := assignmentSources(exp)
+	if len(assigns) == 1 {
+		return append(ret, assigns[0])
+	}
+	return append(ret, assigns)
+}
+
+type diffNode struct {
+	index     int
+	exp       *JsonExp
+	key       string
+	condKey   string
+	assignKey string
+	matched   bool
+}
+
+// 用于对齐节点的标识: 有id的节点以id为标识，有条件的节点以条件为标识，没有条件的节点以赋值为标识
+func (m *diffNode) identity() string {
+	if m.exp.meta.ID != "" {
+		return "id:" + m.exp.meta.ID
+	}
+	if len(m.exp.compareExpList) > 0 {
+		return m.condKey
+	}
+	return "assign:" + m.assignKey
+}
+
+func newDiffNodes(list []*JsonExp) []*diffNode {
+	ret := make([]*diffNode, len(list))
+	for i, exp := range list {
+		ret[i] = &diffNode{
+			index:     i,
+			exp:       exp,
+			key:       compactJSON(nodeSource(exp)) + compactJSON(exp.meta),
+			condKey:   compactJSON(conditionSources(exp)),
+			assignKey: compactJSON(assignmentSources(exp)),
+		}
+	}
+	return ret
+}
+
+func changedNode(x, y *diffNode) *NodeDiff {
+	return &NodeDiff{
+		Kind:        NodeChanged,
+		OldIndex:    x.index,
+		NewIndex:    y.index,
+		ID:          y.exp.meta.ID,
+		Conditions:  diffExpList(conditionSources(x.exp), conditionSources(y.exp)),
+		Assignments: diffExpList(assignmentSources(x.exp), assignmentSources(y.exp)),
+		Meta:        diffNodeMeta(x.exp.meta, y.exp.meta),
+	}
+}
+
+func diffNodeMeta(oldMeta, newMeta NodeMeta) []*MetaDiff {
+	var ret []*MetaDiff
+	add := func(field string, oldValue, newValue interface{}) {
+		if oldValue != newValue {
+			ret = append(ret, &MetaDiff{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	timeValue := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t.Format(DateTimeLayouts[0])
+	}
+	add(nodeFieldID, oldMeta.ID, newMeta.ID)
+	add(nodeFieldDescription, oldMeta.Description, newMeta.Description)
+	add(nodeFieldOwner, oldMeta.Owner, newMeta.Owner)
+	add(nodeFieldEnabled, oldMeta.Enabled, newMeta.Enabled)
+	add(nodeFieldStart, timeValue(oldMeta.Start), timeValue(newMeta.Start))
+	add(nodeFieldEnd, timeValue(oldMeta.End), timeValue(newMeta.End))
+	add(nodeFieldWeight, oldMeta.Weight, newMeta.Weight)
+	return ret
+}
+
+func diffGroupNodes(oldList, newList []*JsonExp) []*NodeDiff {
+	a, b := newDiffNodes(oldList), newDiffNodes(newList)
+	var ret []*NodeDiff
+	// 按标识的最长公共子序列对齐节点，对齐的节点位置没有变化，内容不同时为changed
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i].identity() == b[j].identity() {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i].identity() == b[j].identity() {
+			a[i].matched, b[j].matched = true, true
+			if a[i].key != b[j].key {
+				ret = append(ret, changedNode(a[i], b[j]))
+			}
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	pair := func(same func(x, y *diffNode) bool, build func(x, y *diffNode) *NodeDiff) {
+		for _, x := range a {
+			if x.matched {
+				continue
+			}
+			for _, y := range b {
+				if !y.matched && same(x, y) {
+					x.matched, y.matched = true, true
+					ret = append(ret, build(x, y))
+					break
+				}
+			}
+		}
+	}
+	pair(func(x, y *diffNode) bool { return x.key == y.key }, func(x, y *diffNode) *NodeDiff {
+		return &NodeDiff{Kind: NodeMoved, OldIndex: x.index, NewIndex: y.index, ID: y.exp.meta.ID, Node: nodeSource(y.exp)}
+	})
+	pair(func(x, y *diffNode) bool {
+		return x.identity() == y.identity() || x.assignKey == y.assignKey
+	}, changedNode)
+	for _, x := range a {
+		if !x.matched {
+			ret = append(ret, &NodeDiff{Kind: NodeDeleted, OldIndex: x.index, NewIndex: -1, ID: x.exp.meta.ID, Node: nodeSource(x.exp)})
+		}
+	}
+	for _, y := range b {
+		if !y.matched {
+			ret = append(ret, &NodeDiff{Kind: NodeInserted, OldIndex: -1, NewIndex: y.index, ID: y.exp.meta.ID, Node: nodeSource(y.exp)})
+		}
+	}
+	// 按在新表达式组中的位置排序，删除的节点按原来的位置排在同一位置的其他节点之前
+	position := func(n *NodeDiff) int {
+		if n.NewIndex >= 0 {
+			return n.NewIndex
+		}
+		return n.OldIndex
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if pi, pj := position(ret[i]), position(ret[j]); pi != pj {
+			return pi < pj
+		}
+		return ret[i].Kind == NodeDeleted && ret[j].Kind != NodeDeleted
+	})
+	return ret
+}
+
+// 表达式列表的差异。只有顺序不同时(比较表达式按顺序短路执行)，报告删除全部原来的表达式并新增全部新的表达式
+func diffExpList(oldList, newList []interface{}) []*ExpDiff {
+	oldKeys := make([]string, len(oldList))
+	for i, v := range oldList {
+		oldKeys[i] = compactJSON(v)
+	}
+	newKeys := make([]string, len(newList))
+	for i, v := range newList {
+		newKeys[i] = compactJSON(v)
+	}
+	if strings.Join(oldKeys, "\n") == strings.Join(newKeys, "\n") {
+		return nil
+	}
+	remaining := make(map[string]int)
+	for _, k := range newKeys {
+		remaining[k]++
+	}
+	var ret []*ExpDiff
+	for i, k := range oldKeys {
+		if remaining[k] > 0 {
+			remaining[k]--
+			continue
+		}
+		ret = append(ret, &ExpDiff{Kind: DiffRemoved, Exp: oldList[i].([]interface{})})
+	}
+	remaining = make(map[string]int)
+	for _, k := range oldKeys {
+		remaining[k]++
+	}
+	for i, k := range newKeys {
+		if remaining[k] > 0 {
+			remaining[k]--
+			continue
+		}
+		ret = append(ret, &ExpDiff{Kind: DiffAdded, Exp: newList[i].([]interface{})})
+	}
+	if len(ret) == 0 {
+		for _, v := range oldList {
+			ret = append(ret, &ExpDiff{Kind: DiffRemoved, Exp: v.([]interface{})})
+		}
+		for _, v := range newList {
+			ret = append(ret, &ExpDiff{Kind: DiffAdded, Exp: v.([]interface{})})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// jsonexpdiff 比较两个jsonexp配置文件的键值和表达式组:
+//
+//	go run github.com/truexf/goutil/jsonexp/diff [-format text|json] old.json new.json
+//
+// 与diff命令相同，没有差异时退出码为0，有差异时为1，出错时为2
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/truexf/goutil/jsonexp"
+)
+
+func main() {
+	format := flag.String("format", "text", "output format, text or json")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jsonexpdiff [-format text|json] old-config new-config")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || (*format != "text" && *format != "json") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	diff, err := run(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if *format == "json" {
+		data, _ := json.MarshalIndent(diff, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Print(diff.String())
+	}
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
+
+func run(oldFile, newFile string) (*jsonexp.ConfigDiff, error) {
+	dict := jsonexp.NewDictionary()
+	oldCfg, err := jsonexp.LoadConfigurationFile(oldFile, dict)
+	if err != nil {
+		return nil, fmt.Errorf("load %s fail, %s", oldFile, err.Error())
+	}
+	newCfg, err := jsonexp.LoadConfigurationFile(newFile, dict)
+	if err != nil {
+		return nil, fmt.Errorf("load %s fail, %s", newFile, err.Error())
+	}
+	return jsonexp.DiffConfigurations(oldCfg, newCfg), nil
+}
@@ -0,0 +1,127 @@
+package jsonexp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffConfigurations(t *testing.T) {
+	oldCfg, err := NewConfiguration([]byte(`{
+		"timeout": 30,
+		"region": "cn",
+		"db": {"host": "a"},
+		"removed_group": [[["$a", "=", 1]]],
+		"g": [
+			[["$city", "=", "beijing"], ["$price", "=", 1]],
+			[["$city", "=", "shanghai"], ["$price", "=", 2]],
+			[["$vip", "=", 1], ["$discount", "=", 0.8]],
+			[["$age", ">", 60], ["$discount", "=", 0.5]],
+			[["$tmp", "=", 1]]
+		]
+	}`), NewDictionary())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	newCfg, err := NewConfiguration([]byte(`{
+		"timeout": 60,
+		"db": {"host": "a"},
+		"debug": true,
+		"added_group": [[["$a", "=", 1]], [["$b", "=", 1]]],
+		"g": [
+			[["$age", ">", 60], ["$discount", "=", 0.5]],
+			[["$city", "=", "beijing"], ["$price", "=", 1]],
+			[["$city", "=", "shanghai"], ["$price", "=", 3]],
+			[["$vip", "=", 1], ["$level", ">", 2], ["$discount", "=", 0.8]],
+			[["$new", "=", 1]]
+		]
+	}`), NewDictionary())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	diff := DiffConfigurations(oldCfg, newCfg)
+	text := diff.String()
+	expect := `+ debug: true
+- region: "cn"
+~ timeout: 30 -> 60
++ group added_group (2 nodes)
+~ group g
+    > node 3 -> 0: [["$age",">",60],["$discount","=",0.5]]
+    ~ node 1 -> 2
+        - assignment ["$price","=",2]
+        + assignment ["$price","=",3]
+    ~ node 2 -> 3
+        + condition ["$level",">",2]
+    - node 4: [["$tmp","=",1]]
+    + node 4: [["$new","=",1]]
+- group removed_group (1 nodes)
+`
+	if text != expect {
+		t.Fatalf("diff:\n%s\nexpect:\n%s", text, expect)
+	}
+
+	data, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var decoded ConfigDiff
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(decoded.Groups) != 3 || len(decoded.Groups[1].Nodes) != 5 || decoded.Groups[1].Nodes[4].NewIndex != 4 {
+		t.Fatalf("unexpected json diff %s", string(data))
+	}
+	if !strings.Contains(string(data), `"kind":"moved","old_index":3,"new_index":0`) {
+		t.Fatalf("unexpected json diff %s", string(data))
+	}
+
+	if !DiffConfigurations(oldCfg, oldCfg).Empty() {
+		t.Fatalf("expect no difference")
+	}
+}
+
+func TestDiffDeclarations(t *testing.T) {
+	oldCfg, err := NewConfiguration([]byte(`{
+		"vars": {"$age": "int", "$city": "string"},
+		"buckets": {"enrich": {"qps": 500}, "per_user": {"qps": 100, "per_key": true}},
+		"g": [[["$_", "ratelimit", "enrich"], ["$age", "=", 1]]]
+	}`), NewDictionary())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer oldCfg.Close()
+	newCfg, err := NewConfiguration([]byte(`{
+		"vars": {"$age": {"type": "int", "default": 0}, "$vip": "bool"},
+		"buckets": {"enrich": {"capacity": 1000, "qps": 500}},
+		"g": [[["$_", "ratelimit", "enrich"], ["$age", "=", 1]]]
+	}`), NewDictionary())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer newCfg.Close()
+	diff := DiffConfigurations(oldCfg, newCfg)
+	expect := `~ var $age: {"type":"int"} -> {"default":0,"type":"int"}
+- var $city: {"type":"string"}
++ var $vip: {"type":"bool"}
+~ bucket enrich: {"capacity":500,"qps":500} -> {"capacity":1000,"qps":500}
+- bucket per_user: {"capacity":100,"max_keys":10000,"per_key":true,"qps":100}
+`
+	if text := diff.String(); text != expect {
+		t.Fatalf("diff:\n%s\nexpect:\n%s", text, expect)
+	}
+	if diff.Empty() || len(diff.Groups) != 0 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if !DiffConfigurations(newCfg, newCfg).Empty() {
+		t.Fatalf("expect no difference")
+	}
+}
+
+func TestDiffConditionOrder(t *testing.T) {
+	oldCfg, _ := NewConfiguration([]byte(`{"g": [[["$a", "=", 1], ["$b", "=", 1], ["$c", "=", 1]]]}`), NewDictionary())
+	newCfg, _ := NewConfiguration([]byte(`{"g": [[["$b", "=", 1], ["$a", "=", 1], ["$c", "=", 1]]]}`), NewDictionary())
+	nodes := DiffConfigurations(oldCfg, newCfg).Groups[0].Nodes
+	if len(nodes) != 1 || nodes[0].Kind != NodeChanged || len(nodes[0].Conditions) != 4 {
+		t.Fatalf("reordered conditions should be reported")
+	}
+}
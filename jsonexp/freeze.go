@@ -0,0 +1,181 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// 字典的不可变快照:
+//
+//	builder := jsonexp.NewDictionary()
+//	builder.RegisterVar("$city", cityFunc)
+//	dict := builder.Freeze()
+//	cfg, err := jsonexp.NewConfiguration(source, dict)
+//
+// 快照复制了字典当前注册的变量、对象、运算符、管道函数、变量声明和赋值观察者，
+// 使用快照编译的表达式组在执行时查找这些注册信息不需要加锁。
+// 快照不能再注册: 返回error的注册方法返回错误，其他注册和设置方法(RegisterCompare、SetClock、SetCounterStore、
+// SetEnvAllowlist等)panic。
+// 之后在原字典上的注册不影响已有的快照，再次调用Freeze生成新的快照，没有新的注册时返回同一个快照。
+// 快照与原字典共享对象、变量的TTL缓存和频次计数器存储，时钟和$env的白名单为Freeze时字典的设置
+func (m *Dictionary) Freeze() *Dictionary {
+	if m.frozen {
+		return m
+	}
+	m.freezeLock.Lock()
+	defer m.freezeLock.Unlock()
+	version := atomic.LoadUint64(&m.version)
+	if m.snapshot != nil && m.snapshotVersion == version {
+		return m.snapshot
+	}
+	ret := &Dictionary{
+		frozen:       true,
+		counterStore: m.CounterStore(),
+		envObject:    m.envObject.clone(),
+	}
+	ret.clock.Store(m.clock.Load())
+	m.varListLock.RLock()
+	ret.varList = make(map[string]VarFunc, len(m.varList))
+	for k, v := range m.varList {
+		ret.varList[k] = v
+	}
+	m.varListLock.RUnlock()
+	m.varDeclListLock.RLock()
+	ret.varDeclList = make(map[string]*VarDecl, len(m.varDeclList))
+	for k, v := range m.varDeclList {
+		ret.varDeclList[k] = v
+	}
+	m.varDeclListLock.RUnlock()
+	m.varCacheListLock.RLock()
+	ret.varCacheList = make(map[string]*varCache, len(m.varCacheList))
+	for k, v := range m.varCacheList {
+		ret.varCacheList[k] = v
+	}
+	m.varCacheListLock.RUnlock()
+	m.objectListLock.RLock()
+	ret.objectList = make(map[string]Object, len(m.objectList))
+	for k, v := range m.objectList {
+		if v == Object(m.envObject) {
+			v = ret.envObject
+		}
+		ret.objectList[k] = v
+	}
+	m.objectListLock.RUnlock()
+	m.assignListLock.RLock()
+	ret.assignList = make(map[string]AssignFunc, len(m.assignList))
+	for k, v := range m.assignList {
+		ret.assignList[k] = v
+	}
+	m.assignListLock.RUnlock()
+	m.compareListLock.RLock()
+	ret.compareList = make(map[string]CompareFunc, len(m.compareList))
+	for k, v := range m.compareList {
+		ret.compareList[k] = v
+	}
+	m.compareListLock.RUnlock()
+	m.pipeFunctionListLock.RLock()
+	ret.pipeFunctionList = make(map[string]PipeFunction, len(m.pipeFunctionList))
+	for k, v := range m.pipeFunctionList {
+		ret.pipeFunctionList[k] = v
+	}
+	ret.pipeArgFunctionList = make(map[string]PipeArgFunction, len(m.pipeArgFunctionList))
+	for k, v := range m.pipeArgFunctionList {
+		ret.pipeArgFunctionList[k] = v
+	}
+	m.pipeFunctionListLock.RUnlock()
+	m.assignObserverListLock.RLock()
+	ret.assignObserverList = append([]AssignObserver{}, m.assignObserverList...)
+	m.assignObserverListLock.RUnlock()
+	m.rebind(ret)
+
+	m.snapshot = ret
+	m.snapshotVersion = version
+	return ret
+}
+
+// 使用字典的时钟或频次计数器存储的内置运算符和管道函数，NewDictionary注册时绑定在字典上，
+// Freeze时未被重新注册的重新绑定到快照上
+func (m *Dictionary) boundCompares() map[string]CompareFunc {
+	return map[string]CompareFunc{
+		"dt>":        m.dateTimeCompare(func(r int) bool { return r > 0 }),
+		"dt>=":       m.dateTimeCompare(func(r int) bool { return r >= 0 }),
+		"dt<":        m.dateTimeCompare(func(r int) bool { return r < 0 }),
+		"dt<=":       m.dateTimeCompare(func(r int) bool { return r <= 0 }),
+		"dt=":        m.dateTimeCompare(func(r int) bool { return r == 0 }),
+		"dt!=":       m.dateTimeCompare(func(r int) bool { return r != 0 }),
+		"dtbetween":  m.dateTimeBetween(false),
+		"^dtbetween": m.dateTimeBetween(true),
+	}
+}
+
+func (m *Dictionary) boundAssigns() map[string]AssignFunc {
+	return map[string]AssignFunc{AssignIncr: m.incrAssign}
+}
+
+func (m *Dictionary) boundPipeArgFunctions() map[string]PipeArgFunction {
+	return map[string]PipeArgFunction{PipelineFnFreq: m.pipeFnFreq}
+}
+
+func (m *Dictionary) rebind(snapshot *Dictionary) {
+	for name, fn := range snapshot.boundCompares() {
+		if _, ok := m.overridden.Load("compare:" + name); !ok {
+			snapshot.compareList[name] = fn
+		}
+	}
+	for name, fn := range snapshot.boundAssigns() {
+		if _, ok := m.overridden.Load("assign:" + name); !ok {
+			snapshot.assignList[name] = fn
+		}
+	}
+	for name, fn := range snapshot.boundPipeArgFunctions() {
+		if _, ok := m.overridden.Load("pipe:" + name); !ok {
+			snapshot.pipeArgFunctionList[name] = fn
+		}
+	}
+}
+
+// 是否是Freeze生成的快照
+func (m *Dictionary) Frozen() bool {
+	return m.frozen
+}
+
+func noUnlock() {}
+
+// 读取注册信息时加读锁，快照不加锁
+func (m *Dictionary) rlock(lock *sync.RWMutex) func() {
+	if m.frozen {
+		return noUnlock
+	}
+	lock.RLock()
+	return lock.RUnlock
+}
+
+// 注册信息发生变化，之后的Freeze生成新的快照
+func (m *Dictionary) changed() {
+	atomic.AddUint64(&m.version, 1)
+}
+
+// 快照上调用没有返回值的注册或设置方法时panic，避免注册被静默忽略
+func (m *Dictionary) mustNotFrozen(method string) {
+	if m.frozen {
+		panic(fmt.Sprintf("jsonexp: %s called on a frozen dictionary", method))
+	}
+}
+
+func frozenError() error {
+	return fmt.Errorf("dictionary is frozen")
+}
+
+// 快照中只能重复已有的变量声明，例如重新加载同一个配置
+func (m *Dictionary) checkFrozenVarDecl(decl *VarDecl) error {
+	if old, ok := m.varDeclList[decl.Name]; ok && reflect.DeepEqual(*old, *decl) {
+		return nil
+	}
+	return fmt.Errorf("dictionary is frozen, declare variable %s before Freeze", decl.Name)
+}
@@ -0,0 +1,175 @@
+package jsonexp
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestFreeze(t *testing.T) {
+	builder := NewDictionary()
+	builder.RegisterVar("$city", func(context Context) (interface{}, error) {
+		return "beijing", nil
+	})
+	builder.RegisterVar("$price", nil)
+	dict := builder.Freeze()
+	if !dict.Frozen() || builder.Frozen() {
+		t.Fatalf("only the snapshot should be frozen")
+	}
+	if builder.Freeze() != dict || dict.Freeze() != dict {
+		t.Fatalf("expect the same snapshot without new registrations")
+	}
+	if err := dict.RegisterVar("$x", nil); err == nil {
+		t.Fatalf("expect error registering in a frozen dictionary")
+	}
+	for name, register := range map[string]func(){
+		"RegisterCompare":   func() { dict.RegisterCompare("never", Equal) },
+		"SetClock":          func() { dict.SetClock(time.Now) },
+		"SetCounterStore":   func() { dict.SetCounterStore(NewCounterStore(CounterStoreOptions{})) },
+		"SetEnvAllowlist":   func() { dict.SetEnvAllowlist([]string{"X"}) },
+		"AddAssignObserver": func() { dict.AddAssignObserver(func(*AssignEvent, Context) {}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s on a frozen dictionary should panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+	if _, ok := dict.GetCompareFunc("never"); ok {
+		t.Fatalf("frozen dictionary should not be changed")
+	}
+
+	// 配置中的变量声明不修改字典，可以在快照上加载
+	cfg, err := NewConfiguration([]byte(`{
+		"vars": {"$level": "int"},
+		"g": [[["$city", "=", "beijing"], [["$price", "=", 10], ["$level", "=", "3"]]]]
+	}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dict.GetVarDecl("$level"); ok {
+		t.Fatalf("configuration should not declare variables in the dictionary")
+	}
+	builder.DeclareVar(&VarDecl{Name: "$level", Type: VarInt})
+	dict2 := builder.Freeze()
+	if dict2 == dict {
+		t.Fatalf("expect a new snapshot after registration")
+	}
+	if _, ok := dict.GetVarDecl("$level"); ok {
+		t.Fatalf("old snapshot should not see later registrations")
+	}
+	cfg, err = NewConfiguration([]byte(`{
+		"vars": {"$level": "int"},
+		"g": [[["$city", "=", "beijing"], [["$price", "=", 10], ["$level", "=", "3"]]]]
+	}`), dict2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+
+	// 执行快照编译的表达式组的同时在原字典上注册
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				ctx := &goutil.DefaultContext{}
+				if err := g.Execute(ctx); err != nil {
+					t.Errorf(err.Error())
+					return
+				}
+				if v, _ := ctx.GetCtxData("$level"); v != int64(3) {
+					t.Errorf("$level = %#v", v)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		builder.RegisterVar(fmt.Sprintf("$v%d", i), nil)
+		builder.RegisterCompare(fmt.Sprintf("cmp%d", i), Equal)
+	}
+	wg.Wait()
+	if _, ok := dict2.getVarFunc("$v0"); ok {
+		t.Fatalf("snapshot should not see later registrations")
+	}
+	if _, ok := builder.Freeze().getVarFunc("$v199"); !ok {
+		t.Fatalf("new snapshot should see later registrations")
+	}
+}
+
+func TestFreezeCopiesSettings(t *testing.T) {
+	os.Setenv("JSONEXP_TEST_FREEZE", "v")
+	defer os.Unsetenv("JSONEXP_TEST_FREEZE")
+	builder := NewDictionary()
+	builder.SetEnvAllowlist([]string{"JSONEXP_TEST_*"})
+	fixed := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	builder.SetClock(func() time.Time { return fixed })
+	builder.RegisterVar("$t", nil)
+	builder.RegisterVar("$recent", nil)
+	dict := builder.Freeze()
+	ctx := &goutil.DefaultContext{}
+
+	builder.SetEnvAllowlist([]string{})
+	builder.SetClock(nil)
+	if v, _ := dict.GetVarValue("$env.JSONEXP_TEST_FREEZE", ctx); v != "v" {
+		t.Fatalf("allowlist of the builder should not change the snapshot, got %v", v)
+	}
+	if v, _ := builder.GetVarValue("$env.JSONEXP_TEST_FREEZE", ctx); v != nil {
+		t.Fatalf("allowlist should apply to the builder, got %v", v)
+	}
+	if !dict.Now().Equal(fixed) {
+		t.Fatalf("clock of the builder should not change the snapshot")
+	}
+	cfg, err := NewConfiguration([]byte(`{"g": [[["$t", "dt>", "now-1d"], ["$recent", "=", 1]]]}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx.SetCtxData("$t", "2020-12-31 12:00:00")
+	if err := g.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ctx.GetCtxData("$recent"); !ok {
+		t.Fatalf("date time compare in the snapshot should use the snapshot clock")
+	}
+	dict2 := builder.Freeze()
+	if dict2 == dict {
+		t.Fatalf("expect a new snapshot after changing settings")
+	}
+	if v, _ := dict2.GetVarValue("$env.JSONEXP_TEST_FREEZE", ctx); v != nil {
+		t.Fatalf("new snapshot should use the new allowlist, got %v", v)
+	}
+}
+
+func BenchmarkFrozenDictionary(b *testing.B) {
+	builder := NewDictionary()
+	builder.RegisterVar("$city", nil)
+	builder.RegisterVar("$price", nil)
+	source := []byte(`{"g": [[["$city", "in", "beijing,shanghai"], ["$price", "+=", 1]]]}`)
+	for _, frozen := range []bool{false, true} {
+		dict := builder
+		if frozen {
+			dict = builder.Freeze()
+		}
+		cfg, _ := NewConfiguration(source, dict)
+		g, _ := cfg.GetJsonExpGroup("g")
+		b.Run(fmt.Sprintf("frozen=%v", frozen), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					ctx := &goutil.DefaultContext{}
+					ctx.SetCtxData("$city", "beijing")
+					g.Execute(ctx)
+				}
+			})
+		})
+	}
+}
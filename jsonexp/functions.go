@@ -96,20 +96,22 @@ var NotCover = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var Cover = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkStringOperands(L, R, true); err != nil {
+		return false, err
+	}
 	l, lOk := GetStringValue(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
 	}
-	r, rOk := GetStringValue(R)
+	rList, rOk := getStringList(R)
 	if !rOk {
 		return false, fmt.Errorf("right value is not string incompatible")
 	}
 
-	if l == "" || r == "" {
+	if l == "" || len(rList) == 0 {
 		return false, nil
 	}
 
-	rList := strings.Split(r, ",")
 	for _, v := range rList {
 		if strings.Contains(l, v) {
 			return true, nil
@@ -119,6 +121,9 @@ var Cover = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var Contain = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkStringOperands(L, R, false); err != nil {
+		return false, err
+	}
 	l, lOk := GetStringValue(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
@@ -137,6 +142,9 @@ var Contain = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var RegExpMatch = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkStringOperands(L, R, false); err != nil {
+		return false, err
+	}
 	l, lOk := GetStringValue(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
@@ -166,6 +174,9 @@ var NotContain = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var HeadMatch = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkStringOperands(L, R, false); err != nil {
+		return false, err
+	}
 	l, lOk := GetStringValue(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
@@ -192,6 +203,9 @@ var NotHeadMatch = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var TailMatch = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkStringOperands(L, R, false); err != nil {
+		return false, err
+	}
 	l, lOk := GetStringValue(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
@@ -226,21 +240,19 @@ var None = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var Any = func(L, R interface{}, context Context) (bool, error) {
-	l, lOk := GetStringValue(L)
+	lList, lOk := getStringList(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
 	}
-	r, rOk := GetStringValue(R)
+	rList, rOk := getStringList(R)
 	if !rOk {
 		return false, fmt.Errorf("right value not string-incompatible")
 	}
 
-	if l == "" || r == "" {
+	if len(lList) == 0 || len(rList) == 0 {
 		return false, nil
 	}
 
-	lList := strings.Split(l, ",")
-	rList := strings.Split(r, ",")
 	any := false
 	for _, v := range rList {
 		for _, vL := range lList {
@@ -257,21 +269,19 @@ var Any = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var Has = func(L, R interface{}, context Context) (bool, error) {
-	l, lOk := GetStringValue(L)
+	lList, lOk := getStringList(L)
 	if !lOk {
 		return false, fmt.Errorf("invalid L")
 	}
-	r, rOk := GetStringValue(R)
+	rList, rOk := getStringList(R)
 	if !rOk {
 		return false, fmt.Errorf("right value not string-incompatible")
 	}
 
-	if l == "" || r == "" {
+	if len(lList) == 0 || len(rList) == 0 {
 		return false, nil
 	}
 
-	lList := strings.Split(l, ",")
-	rList := strings.Split(r, ",")
 	for _, v := range rList {
 		has := false
 		for _, vL := range lList {
@@ -288,39 +298,38 @@ var Has = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var NotIn = func(L, R interface{}, context Context) (bool, error) {
-	l, lOk := GetStringValue(L)
-	if !lOk {
-		return false, fmt.Errorf("invalid L")
-	}
-	if r, ok := GetStringValue(R); !ok {
-		return false, fmt.Errorf("right value not string-incompatible")
-	} else {
-		rList := strings.Split(r, ",")
-		in := false
-		for _, v := range rList {
-			if l == v {
-				in = true
-				break
-			}
-		}
-		return !in, nil
+	ret, err := In(L, R, context)
+	if err != nil {
+		return false, err
 	}
+	return !ret, nil
 }
 
 var In = func(L, R interface{}, context Context) (bool, error) {
-	l, lOk := GetStringValue(L)
-	if !lOk {
-		return false, fmt.Errorf("invalid L")
+	if GetValueType(L) == VarSlice {
+		return false, fmt.Errorf("invalid L, list value, use has or any instead")
 	}
-	if r, ok := GetStringValue(R); !ok {
+	rList, ok := GetListValue(R)
+	if !ok {
 		return false, fmt.Errorf("right value not string-incompatible")
-	} else {
-		rList := strings.Split(r, ",")
+	}
+	// null只与null匹配，其他值按listItemKey比较
+	if L == nil {
 		for _, v := range rList {
-			if l == v {
+			if v == nil {
 				return true, nil
 			}
 		}
+		return false, nil
+	}
+	if _, ok := GetStringValue(L); !ok {
+		return false, fmt.Errorf("invalid L")
+	}
+	l := listItemKey(L)
+	for _, v := range rList {
+		if v != nil && l == listItemKey(v) {
+			return true, nil
+		}
 	}
 	return false, nil
 }
@@ -387,6 +396,12 @@ var Between = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var NotEqual = func(L, R interface{}, context Context) (bool, error) {
+	if eq, handled, err := equalValues(L, R); handled {
+		if err != nil {
+			return false, err
+		}
+		return !eq, nil
+	}
 	tp := GetValueType(L)
 	if tp == VarInvalid {
 		return false, fmt.Errorf("invalid param L")
@@ -416,6 +431,9 @@ var NotEqual = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var Equal = func(L, R interface{}, context Context) (bool, error) {
+	if eq, handled, err := equalValues(L, R); handled {
+		return eq, err
+	}
 	tp := GetValueType(L)
 	if tp == VarInvalid {
 		return false, fmt.Errorf("invalid param L")
@@ -445,6 +463,9 @@ var Equal = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var LessEqual = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkOrderedOperands(L, R); err != nil {
+		return false, err
+	}
 	tp := GetValueType(L)
 	if tp == VarInvalid {
 		return false, fmt.Errorf("invalid param L")
@@ -474,6 +495,9 @@ var LessEqual = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var Less = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkOrderedOperands(L, R); err != nil {
+		return false, err
+	}
 	tp := GetValueType(L)
 	if tp == VarInvalid {
 		return false, fmt.Errorf("invalid param L")
@@ -503,6 +527,9 @@ var Less = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var MoreEqual = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkOrderedOperands(L, R); err != nil {
+		return false, err
+	}
 	tp := GetValueType(L)
 	if tp == VarInvalid {
 		return false, fmt.Errorf("invalid param L")
@@ -532,6 +559,9 @@ var MoreEqual = func(L, R interface{}, context Context) (bool, error) {
 }
 
 var More = func(L, R interface{}, context Context) (bool, error) {
+	if err := checkOrderedOperands(L, R); err != nil {
+		return false, err
+	}
 	tp := GetValueType(L)
 	if tp == VarInvalid {
 		return false, fmt.Errorf("invalid param L")
@@ -565,6 +595,9 @@ var Assign = func(L string, lValue interface{}, R interface{}, ret Context) erro
 	if ret == nil {
 		return fmt.Errorf("param ret is nil")
 	}
+	if err := getExecState(ret).checkValueSize(R); err != nil {
+		return err
+	}
 	ret.SetCtxData(L, R)
 	return nil
 }
@@ -576,13 +609,24 @@ var AddAssign = func(L string, lValue interface{}, R interface{}, ret Context) e
 
 	lType := GetValueType(lValue)
 	vType := GetValueType(R)
-	if lType == VarInvalid {
+	if lType == VarNull {
 		lType = vType
 	}
+	if lType != VarStr {
+		if err := checkArithmeticOperands(lValue, R); err != nil {
+			return err
+		}
+	} else if vType == VarNull || vType == VarBool || vType == VarSlice {
+		return fmt.Errorf("invalid operand, %s value of right", vType.String())
+	}
 	switch lType {
 	case VarStr:
 		old, _ := GetStringValue(lValue)
 		add, _ := GetStringValue(R)
+		// 在分配内存之前检查执行限制
+		if err := getExecState(ret).checkStringSize(len(old) + len(add)); err != nil {
+			return err
+		}
 		ret.SetCtxData(L, old+add)
 	case VarFloat:
 		old, _ := GetFloatValue(lValue)
@@ -603,14 +647,19 @@ var SubAssign = func(L string, lValue interface{}, R interface{}, ret Context) e
 		return fmt.Errorf("param ret is nil")
 	}
 
+	if err := checkArithmeticOperands(lValue, R); err != nil {
+		return err
+	}
 	vType := GetValueType(R)
 	switch vType {
 	case VarFloat:
 		old, _ := GetFloatValue(lValue)
-		ret.SetCtxData(L, old-R.(float64))
+		sub, _ := GetFloatValue(R)
+		ret.SetCtxData(L, old-sub)
 	case VarInt:
 		old, _ := GetIntValue(lValue)
-		ret.SetCtxData(L, old-R.(int64))
+		sub, _ := GetIntValue(R)
+		ret.SetCtxData(L, old-sub)
 	default:
 		return fmt.Errorf("invalid operand")
 	}
@@ -624,23 +673,38 @@ var MulAssign = func(L string, lValue interface{}, R interface{}, ret Context) e
 
 	lType := GetValueType(lValue)
 	vType := GetValueType(R)
-	if lType == VarInvalid {
+	if lType == VarNull {
 		lType = vType
 	}
+	if lType != VarStr {
+		if err := checkArithmeticOperands(lValue, R); err != nil {
+			return err
+		}
+	}
 	switch lType {
 	case VarStr:
 		if vType == VarInt {
 			old, _ := GetStringValue(lValue)
-			ret.SetCtxData(L, strings.Repeat(old, R.(int)))
+			// 在分配内存之前检查执行限制
+			n, _ := GetIntValue(R)
+			if n < 0 {
+				return fmt.Errorf("invalid operand")
+			}
+			if err := getExecState(ret).checkRepeatSize(len(old), n); err != nil {
+				return err
+			}
+			ret.SetCtxData(L, strings.Repeat(old, int(n)))
 		} else {
 			return fmt.Errorf("invalid operand")
 		}
 	case VarFloat:
 		old, _ := GetFloatValue(lValue)
-		ret.SetCtxData(L, old*R.(float64))
+		mul, _ := GetFloatValue(R)
+		ret.SetCtxData(L, old*mul)
 	case VarInt:
 		old, _ := GetIntValue(lValue)
-		ret.SetCtxData(L, old*R.(int64))
+		mul, _ := GetIntValue(R)
+		ret.SetCtxData(L, old*mul)
 	default:
 		return fmt.Errorf("invalid operand")
 	}
@@ -651,6 +715,9 @@ var DivAssign = func(L string, lValue interface{}, R interface{}, ret Context) e
 	if ret == nil {
 		return fmt.Errorf("param ret is nil")
 	}
+	if err := checkArithmeticOperands(lValue, R); err != nil {
+		return err
+	}
 
 	old, _ := GetFloatValue(lValue)
 	add, _ := GetFloatValue(R)
@@ -665,6 +732,9 @@ var ModAssign = func(L string, lValue interface{}, R interface{}, ret Context) e
 	if ret == nil {
 		return fmt.Errorf("param ret is nil")
 	}
+	if err := checkArithmeticOperands(lValue, R); err != nil {
+		return err
+	}
 
 	old, _ := GetFloatValue(lValue)
 	add, _ := GetFloatValue(R)
@@ -675,3 +745,121 @@ var ModAssign = func(L string, lValue interface{}, R interface{}, ret Context) e
 	ret.SetCtxData(L, int64(old)%addInt)
 	return nil
 }
+
+// 左值为空(不存在、nil、空字符串或者空列表)时才赋值
+var ConditionalAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+
+	empty := lValue == nil
+	if !empty {
+		switch GetValueType(lValue) {
+		case VarStr:
+			empty = lValue.(string) == ""
+		case VarSlice:
+			list, _ := GetListValue(lValue)
+			empty = len(list) == 0
+		}
+	}
+	if empty {
+		if err := getExecState(ret).checkValueSize(R); err != nil {
+			return err
+		}
+		ret.SetCtxData(L, R)
+	}
+	return nil
+}
+
+// 比较左值和右值，less为true时返回较小者，否则返回较大者。左值的类型决定比较方式，左值不存在时返回右值
+func minMaxValue(lValue interface{}, R interface{}, less bool) (interface{}, error) {
+	// 右值是否应该取代左值
+	replace := func(rLessThanL, equal bool) bool {
+		if equal {
+			return false
+		}
+		return rLessThanL == less
+	}
+	if tp := GetValueType(R); tp == VarNull || tp == VarBool || tp == VarSlice {
+		return nil, fmt.Errorf("invalid operand, %s value of right", tp.String())
+	}
+	switch GetValueType(lValue) {
+	case VarNull:
+		return R, nil
+	case VarStr:
+		l, _ := GetStringValue(lValue)
+		r, ok := GetStringValue(R)
+		if !ok {
+			return nil, fmt.Errorf("invalid operand")
+		}
+		if replace(r < l, r == l) {
+			return r, nil
+		}
+		return l, nil
+	case VarInt:
+		l, _ := GetIntValue(lValue)
+		rf, ok := GetFloatValue(R)
+		if !ok {
+			return nil, fmt.Errorf("invalid operand")
+		}
+		if rf != float64(int64(rf)) {
+			// 右值为小数时按小数比较
+			if replace(rf < float64(l), false) {
+				return rf, nil
+			}
+			return l, nil
+		}
+		r := int64(rf)
+		if replace(r < l, r == l) {
+			return r, nil
+		}
+		return l, nil
+	case VarFloat:
+		l, _ := GetFloatValue(lValue)
+		r, ok := GetFloatValue(R)
+		if !ok {
+			return nil, fmt.Errorf("invalid operand")
+		}
+		if replace(r < l, r == l) {
+			return r, nil
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("invalid operand")
+	}
+}
+
+// 保留左值和右值中较小的一个
+var MinAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	v, err := minMaxValue(lValue, R, true)
+	if err != nil {
+		return err
+	}
+	ret.SetCtxData(L, v)
+	return nil
+}
+
+// 保留左值和右值中较大的一个
+var MaxAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	v, err := minMaxValue(lValue, R, false)
+	if err != nil {
+		return err
+	}
+	ret.SetCtxData(L, v)
+	return nil
+}
+
+// 删除变量，忽略右值
+var UnsetAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	ret.RemoveCtxData(L)
+	return nil
+}
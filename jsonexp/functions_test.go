@@ -0,0 +1,75 @@
+package jsonexp
+
+import (
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+type deletableObj struct {
+	undoableObj
+}
+
+func (m *deletableObj) DeleteProperty(property string, context Context) {
+	delete(m.props, property)
+}
+
+func TestConditionalMinMaxUnsetAssign(t *testing.T) {
+	dict := NewDictionary()
+	for _, v := range []string{"$city", "$name", "$price", "$score", "$word", "$tmp"} {
+		dict.RegisterVar(v, nil)
+	}
+	obj := &deletableObj{undoableObj{props: map[string]interface{}{"icon": "a.jpg"}}}
+	dict.RegisterObject("$resp", obj)
+	dict.RegisterObject("$myobj", &MyObj{})
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[
+				[
+					["$city", "?=", "unknown"],
+					["$name", "?=", "unknown"],
+					["$price", "min=", 10],
+					["$price", "min=", 12],
+					["$score", "max=", 3],
+					["$score", "max=", 1.5],
+					["$word", "max=", "apple"],
+					["$word", "max=", "banana"],
+					["$tmp", "unset", ""],
+					["$resp.icon", "unset", ""]
+				]
+			]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$name", "tom")
+	ctx.SetCtxData("$score", 2)
+	ctx.SetCtxData("$tmp", 1)
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	expect := map[string]interface{}{
+		"$city":  "unknown",
+		"$name":  "tom",
+		"$price": float64(10),
+		"$score": int64(3),
+		"$word":  "banana",
+	}
+	for k, v := range expect {
+		if got, _ := ctx.GetCtxData(k); got != v {
+			t.Fatalf("%s = %#v, expect %#v", k, got, v)
+		}
+	}
+	if _, ok := ctx.GetCtxData("$tmp"); ok {
+		t.Fatalf("$tmp should be unset")
+	}
+	if _, ok := obj.props["icon"]; ok {
+		t.Fatalf("$resp.icon should be unset")
+	}
+	if err := dict.Assign("unset", "$myobj.version", "", ctx); err == nil {
+		t.Fatalf("expect error for non-deletable object")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// jsonexpgen 将jsonexp配置中的表达式组生成为Go代码:
+//
+//	go run github.com/truexf/goutil/jsonexp/gen -config rules.json -pkg rules -out rules_gen.go -test-out rules_gen_test.go
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/truexf/goutil/jsonexp"
+)
+
+func main() {
+	configFile := flag.String("config", "", "configuration file (.json, .jsonc, .yaml)")
+	pkg := flag.String("pkg", "rules", "package name of the generated code")
+	typeName := flag.String("type", "Rules", "type name of the generated code")
+	out := flag.String("out", "", "output file of the generated code, default stdout")
+	testOut := flag.String("test-out", "", "output file of the generated test, no test is generated if empty")
+	samplesFile := flag.String("samples", "", "json file of sample inputs for the generated test, an array of objects")
+	vars := flag.String("vars", "", "comma separated custom variables used by the configuration")
+	flag.Parse()
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*configFile, *pkg, *typeName, *out, *testOut, *samplesFile, *vars); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(configFile, pkg, typeName, out, testOut, samplesFile, vars string) error {
+	dict := jsonexp.NewDictionary()
+	// 配置解析时只检查语法，自定义变量不需要真正的取值函数
+	for _, v := range strings.Split(vars, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			dict.RegisterVar(v, nil)
+		}
+	}
+	cfg, err := jsonexp.LoadConfigurationFile(configFile, dict)
+	if err != nil {
+		return err
+	}
+	opts := jsonexp.GoGenOptions{Package: pkg, TypeName: typeName, Source: filepath.Base(configFile)}
+	if samplesFile != "" {
+		data, err := ioutil.ReadFile(samplesFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &opts.Samples); err != nil {
+			return fmt.Errorf("invalid samples file %s, %s", samplesFile, err.Error())
+		}
+	}
+	code, err := jsonexp.GenerateGo(cfg, opts)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(code)
+	} else {
+		err = ioutil.WriteFile(out, code, 0644)
+	}
+	if err != nil || testOut == "" {
+		return err
+	}
+	testCode, err := jsonexp.GenerateGoTest(cfg, opts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(testOut, testCode, 0644)
+}
@@ -5,14 +5,15 @@
 package jsonexp
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"math/rand"
 	"reflect"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/truexf/goutil"
@@ -30,11 +31,14 @@ const (
 	VarInt
 	VarFloat
 	VarSlice
+	VarBool
+	VarNull
 )
 
+// 值的类型。nil(包括不存在的变量和配置中的null)为VarNull，无法识别的类型为VarInvalid
 func GetValueType(v interface{}) VarType {
 	if v == nil {
-		return VarInvalid
+		return VarNull
 	}
 	rv := reflect.ValueOf(v)
 	switch kd := rv.Kind(); kd {
@@ -46,6 +50,8 @@ func GetValueType(v interface{}) VarType {
 		return VarInt
 	case reflect.Slice:
 		return VarSlice
+	case reflect.Bool:
+		return VarBool
 	default:
 		return VarInvalid
 	}
@@ -70,6 +76,8 @@ func GetStringValue(v interface{}) (string, bool) {
 		return GetStringValue(r)
 	case reflect.String:
 		return fmt.Sprintf("%s", v), true
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), true
 	case reflect.Float64, reflect.Float32:
 		return fmt.Sprintf("%f", v), true
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
@@ -185,53 +193,138 @@ type Object interface {
 	SetPropertyValue(property string, value interface{}, context Context)
 }
 
+// 可选接口，对象实现该接口后，其属性可以通过unset赋值运算符删除
+type DeletableObject interface {
+	Object
+	DeleteProperty(property string, context Context)
+}
+
 type Dictionary struct {
-	varList              map[string]VarFunc
-	varListLock          sync.RWMutex
-	objectList           map[string]Object
-	objectListLock       sync.RWMutex
-	assignList           map[string]AssignFunc
-	assignListLock       sync.RWMutex
-	compareList          map[string]CompareFunc
-	compareListLock      sync.RWMutex
-	pipeFunctionList     map[string]PipeFunction
-	pipeFunctionListLock sync.RWMutex
-	regExpMacro          *regexp.Regexp
+	version                uint64 // 原子操作，放在第一个字段以保证在32位平台上8字节对齐
+	varList                map[string]VarFunc
+	varListLock            sync.RWMutex
+	varDeclList            map[string]*VarDecl
+	varDeclListLock        sync.RWMutex
+	varCacheList           map[string]*varCache
+	varCacheListLock       sync.RWMutex
+	objectList             map[string]Object
+	objectListLock         sync.RWMutex
+	assignList             map[string]AssignFunc
+	assignListLock         sync.RWMutex
+	assignObserverList     []AssignObserver
+	assignObserverListLock sync.RWMutex
+	compareList            map[string]CompareFunc
+	compareListLock        sync.RWMutex
+	pipeFunctionList       map[string]PipeFunction
+	pipeArgFunctionList    map[string]PipeArgFunction
+	pipeFunctionListLock   sync.RWMutex
+	macroTemplates         sync.Map     // string => *macroTemplate
+	clock                  atomic.Value // func() time.Time
+	counterStore           *CounterStore
+	counterStoreLock       sync.RWMutex
+	envObject              *envObject
+	overridden             sync.Map // 被重新注册的内置运算符和管道函数的名称(compare:dt>等)，见boundCompares
+	frozen                 bool     // Freeze生成的快照，注册信息不再变化
+	freezeLock             sync.Mutex
+	snapshot               *Dictionary
+	snapshotVersion        uint64
 }
 
 func NewDictionary() *Dictionary {
 	ret := &Dictionary{varList: make(map[string]VarFunc),
-		objectList:       make(map[string]Object),
-		assignList:       make(map[string]AssignFunc),
-		compareList:      make(map[string]CompareFunc),
-		pipeFunctionList: make(map[string]PipeFunction),
-		regExpMacro:      regexp.MustCompile(`\{\{(\$.+?)\}\}`),
-	}
+		varDeclList:         make(map[string]*VarDecl),
+		varCacheList:        make(map[string]*varCache),
+		objectList:          make(map[string]Object),
+		assignList:          make(map[string]AssignFunc),
+		compareList:         make(map[string]CompareFunc),
+		pipeFunctionList:    make(map[string]PipeFunction),
+		pipeArgFunctionList: make(map[string]PipeArgFunction),
+		counterStore:        NewCounterStore(CounterStoreOptions{}),
+	}
+	ret.clock.Store(time.Now)
 	ret.registerSystemPipeFunction()
 	ret.registerSysemVariants()
 	ret.registerSystemCompares()
 	ret.registerSystemAssign()
+	ret.registerBuiltinObjects()
 	return ret
 }
 
+// 设置字典时钟，日期时间比较运算符中的相对时间(如now-7d)以该时钟为准，默认为time.Now
+func (m *Dictionary) SetClock(clock func() time.Time) {
+	m.mustNotFrozen("SetClock")
+	if clock == nil {
+		clock = time.Now
+	}
+	m.clock.Store(clock)
+	m.changed()
+}
+
+func (m *Dictionary) Now() time.Time {
+	return m.clock.Load().(func() time.Time)()
+}
+
 func (m *Dictionary) RegisterPipeFunction(name string, fn PipeFunction) {
 	if name == "" || fn == nil {
 		return
 	}
+	m.mustNotFrozen("RegisterPipeFunction")
 	m.pipeFunctionListLock.Lock()
 	defer m.pipeFunctionListLock.Unlock()
 	m.pipeFunctionList[name] = fn
+	m.changed()
 }
 
 func (m *Dictionary) GetPipeFunction(name string) PipeFunction {
-	m.pipeFunctionListLock.RLock()
-	defer m.pipeFunctionListLock.RUnlock()
+	defer m.rlock(&m.pipeFunctionListLock)()
 	if ret, ok := m.pipeFunctionList[name]; ok {
 		return ret
 	}
 	return nil
 }
 
+// 注册带参数的管道函数
+func (m *Dictionary) RegisterPipeArgFunction(name string, fn PipeArgFunction) {
+	if name == "" || fn == nil {
+		return
+	}
+	m.mustNotFrozen("RegisterPipeArgFunction")
+	m.overridden.Store("pipe:"+name, true)
+	m.pipeFunctionListLock.Lock()
+	defer m.pipeFunctionListLock.Unlock()
+	m.pipeArgFunctionList[name] = fn
+	m.changed()
+}
+
+func (m *Dictionary) GetPipeArgFunction(name string) PipeArgFunction {
+	defer m.rlock(&m.pipeFunctionListLock)()
+	if ret, ok := m.pipeArgFunctionList[name]; ok {
+		return ret
+	}
+	return nil
+}
+
+// 解析管道中的一段，name、name:arg或者name(arg)
+func (m *Dictionary) resolvePipeFunction(segment string) PipeFunction {
+	if fn := m.GetPipeFunction(segment); fn != nil {
+		return fn
+	}
+	name, arg := "", ""
+	if i := strings.Index(segment, "("); i > 0 && strings.HasSuffix(segment, ")") {
+		name, arg = segment[:i], segment[i+1:len(segment)-1]
+	} else if i := strings.Index(segment, ":"); i > 0 {
+		name, arg = segment[:i], segment[i+1:]
+	}
+	if name != "" {
+		if fn := m.GetPipeArgFunction(name); fn != nil {
+			return func(input interface{}, context Context) (interface{}, error) {
+				return fn(input, arg, context)
+			}
+		}
+	}
+	return nil
+}
+
 func (dict *Dictionary) registerSysemVariants() {
 	dict.RegisterVar("$datetime", DateTime)
 	dict.RegisterVar("$date", Date)
@@ -278,6 +371,20 @@ func (dict *Dictionary) registerSystemCompares() {
 	dict.RegisterCompare("^*~", NotTailMatch)
 	dict.RegisterCompare("cv", Cover)
 	dict.RegisterCompare("^cv", NotCover)
+
+	dict.RegisterCompare("ver>", VersionMore)
+	dict.RegisterCompare("ver>=", VersionMoreEqual)
+	dict.RegisterCompare("ver<", VersionLess)
+	dict.RegisterCompare("ver<=", VersionLessEqual)
+	dict.RegisterCompare("ver=", VersionEqual)
+	dict.RegisterCompare("ver!=", VersionNotEqual)
+	dict.RegisterCompare("verbetween", VersionBetween)
+	dict.RegisterCompare("^verbetween", VersionNotBetween)
+
+	for name, fn := range dict.boundCompares() {
+		dict.compareList[name] = fn
+	}
+	dict.RegisterCompare(CompareRateLimit, RateLimitCompare)
 }
 
 func (dict *Dictionary) registerSystemAssign() {
@@ -287,6 +394,17 @@ func (dict *Dictionary) registerSystemAssign() {
 	dict.RegisterAssign("*=", MulAssign)
 	dict.RegisterAssign("/=", DivAssign)
 	dict.RegisterAssign("%=", ModAssign)
+	dict.RegisterAssign("append", AppendAssign)
+	dict.RegisterAssign("remove", RemoveAssign)
+	dict.RegisterAssign("union", UnionAssign)
+	dict.RegisterAssign("dedupe", DedupeAssign)
+	dict.RegisterAssign("?=", ConditionalAssign)
+	dict.RegisterAssign("min=", MinAssign)
+	dict.RegisterAssign("max=", MaxAssign)
+	dict.RegisterAssign("unset", UnsetAssign)
+	for name, fn := range dict.boundAssigns() {
+		dict.assignList[name] = fn
+	}
 }
 
 func (dict *Dictionary) registerSystemPipeFunction() {
@@ -297,6 +415,11 @@ func (dict *Dictionary) registerSystemPipeFunction() {
 	dict.RegisterPipeFunction(PipelineFnLower, pipeFnLower)
 	dict.RegisterPipeFunction(PipelineFnMd5Lower, pipeFnFnvMd5Lower)
 	dict.RegisterPipeFunction(PipelineFnMd5Upper, pipeFnFnvMd5Upper)
+	dict.RegisterPipeArgFunction(PipelineFnContains, pipeFnContains)
+	dict.RegisterPipeArgFunction(PipelineFnIndex, pipeFnIndex)
+	for name, fn := range dict.boundPipeArgFunctions() {
+		dict.pipeArgFunctionList[name] = fn
+	}
 }
 
 // 注册变量，变量名必须以"$"开头，且不能与object重名
@@ -304,12 +427,16 @@ func (m *Dictionary) RegisterVar(varName string, fetchFunc VarFunc) error {
 	if varName == "" {
 		return fmt.Errorf("varName is empty")
 	}
+	if m.frozen {
+		return frozenError()
+	}
 	if _, ok := m.getObject(varName); ok {
 		return fmt.Errorf("object with the same name exists")
 	}
 	m.varListLock.Lock()
 	defer m.varListLock.Unlock()
 	m.varList[varName] = fetchFunc
+	m.changed()
 	return nil
 }
 
@@ -318,12 +445,16 @@ func (m *Dictionary) RegisterObject(objectName string, object Object) error {
 	if objectName == "" {
 		return fmt.Errorf("objectName is empty")
 	}
+	if m.frozen {
+		return frozenError()
+	}
 	// if _, ok := m.getVarFunc(objectName); ok {
 	// 	return fmt.Errorf("variant with the same name exists")
 	// }
 	m.objectListLock.Lock()
 	defer m.objectListLock.Unlock()
 	m.objectList[objectName] = object
+	m.changed()
 	return nil
 }
 
@@ -336,9 +467,12 @@ func (m *Dictionary) RegisterCompare(compareName string, compareFunc CompareFunc
 	if compareName == "" || compareFunc == nil {
 		return
 	}
+	m.mustNotFrozen("RegisterCompare")
+	m.overridden.Store("compare:"+compareName, true)
 	m.compareListLock.Lock()
 	defer m.compareListLock.Unlock()
 	m.compareList[compareName] = compareFunc
+	m.changed()
 }
 
 // 注册赋值运算符
@@ -346,21 +480,22 @@ func (m *Dictionary) RegisterAssign(assignName string, assignFunc AssignFunc) {
 	if assignName == "" || assignFunc == nil {
 		return
 	}
+	m.mustNotFrozen("RegisterAssign")
+	m.overridden.Store("assign:"+assignName, true)
 	m.assignListLock.Lock()
 	defer m.assignListLock.Unlock()
 	m.assignList[assignName] = assignFunc
+	m.changed()
 }
 
 func (m *Dictionary) getVarFunc(varName string) (VarFunc, bool) {
-	m.varListLock.RLock()
-	defer m.varListLock.RUnlock()
+	defer m.rlock(&m.varListLock)()
 	ret, ok := m.varList[varName]
 	return ret, ok
 }
 
 func (m *Dictionary) getObject(objName string) (Object, bool) {
-	m.objectListLock.RLock()
-	defer m.objectListLock.RUnlock()
+	defer m.rlock(&m.objectListLock)()
 	ret, ok := m.objectList[objName]
 	return ret, ok
 }
@@ -376,19 +511,27 @@ func (m *Dictionary) getObjectFromContext(objName string, context Context) (Obje
 }
 
 func (m *Dictionary) getCompareFunc(compareName string) (CompareFunc, bool) {
-	m.compareListLock.RLock()
-	defer m.compareListLock.RUnlock()
+	defer m.rlock(&m.compareListLock)()
 	ret, ok := m.compareList[compareName]
 	return ret, ok
 }
 
 func (m *Dictionary) getAssignFunc(assignName string) (AssignFunc, bool) {
-	m.assignListLock.RLock()
-	defer m.assignListLock.RUnlock()
+	defer m.rlock(&m.assignListLock)()
 	ret, ok := m.assignList[assignName]
 	return ret, ok
 }
 
+// 按名称获取已注册的比较运算函数
+func (m *Dictionary) GetCompareFunc(compareName string) (CompareFunc, bool) {
+	return m.getCompareFunc(compareName)
+}
+
+// 按名称获取已注册的赋值运算函数
+func (m *Dictionary) GetAssignFunc(assignName string) (AssignFunc, bool) {
+	return m.getAssignFunc(assignName)
+}
+
 // 获取对象的值， varName的格式为: ObjectName.PropertyName
 func (m *Dictionary) getObjectPropertyValue(varName string, context Context) (interface{}, error) {
 	parts := strings.Split(varName, ".")
@@ -405,30 +548,15 @@ func (m *Dictionary) getObjectPropertyValue(varName string, context Context) (in
 	}
 }
 
-func (m *Dictionary) replaceMacro(strValueWithMacro string, context Context) string {
-	matchedList := m.regExpMacro.FindAllStringSubmatch(strValueWithMacro, -1)
-	if len(matchedList) == 0 {
-		return strValueWithMacro
-	}
-	ret := strValueWithMacro
-	for _, v := range matchedList {
-		if len(v) != 2 {
-			continue
-		}
-		macroValue, err := m.getOriginVarValue(v[1], context)
-		if err == nil {
-			if macroValueStr, ok := GetStringValue(macroValue); ok {
-				ret = strings.Replace(ret, v[0], macroValueStr, 1)
-			}
-		}
-	}
-	return ret
-}
-
 func (m *Dictionary) getOriginVarValue(varName string, context Context) (interface{}, error) {
 	fn, varFound := m.getVarFunc(varName)
+	if !varFound {
+		// 配置中声明的变量不需要注册，值只来自上下文或默认值
+		_, varFound = m.lookupVarDecl(varName, context)
+	}
 	if !varFound {
 		if ret, err := m.getObjectPropertyValue(varName, context); err == nil {
+			traceVar(context, varName, ret, VarSourceObject, nil)
 			return ret, nil
 		} else {
 			return nil, fmt.Errorf("variable(or object property) %s not found", varName)
@@ -437,16 +565,26 @@ func (m *Dictionary) getOriginVarValue(varName string, context Context) (interfa
 
 	var ret interface{}
 	var err error
+	source := VarSourceNone
 	// use value from context first
 	if r, ok := context.GetCtxData(varName); ok {
 		ret = r
+		source = VarSourceContext
 	} else if fn != nil {
-		ret, err = fn(context)
+		ret, source, err = m.callVarFunc(varName, fn, context)
 	}
 	if err != nil {
+		traceVar(context, varName, nil, source, err)
 		return nil, err
 	}
-	return ret, nil
+	if ret == nil {
+		if decl, ok := m.lookupVarDecl(varName, context); ok && decl.HasDefault {
+			source = VarSourceDefault
+		}
+	}
+	ret, err = m.coerceVarValue(varName, ret, context)
+	traceVar(context, varName, ret, source, err)
+	return ret, err
 }
 
 func (m *Dictionary) GetVarValue(varName string, context Context) (interface{}, error) {
@@ -480,77 +618,189 @@ func (m *Dictionary) Compare(compareName string, left string, right interface{},
 	if !ok {
 		return false, fmt.Errorf("compare name %s not found", compareName)
 	}
+	return m.CompareWith(fn, left, right, context)
+}
+
+// 使用已获取的比较运算函数进行比较，左值和右值的求值方式与Compare相同
+func (m *Dictionary) CompareWith(fn CompareFunc, left string, right interface{}, context Context) (bool, error) {
 	var leftValue interface{} = left
-	var rightValue interface{} = right
 	if len(left) > 1 && left[0] == '$' {
 		leftValue, _ = m.GetVarValue(left, context)
 	}
-	if rightStr, ok := right.(string); ok {
-		if len(rightStr) > 1 && rightStr[0] == '$' {
-			rightValue, _ = m.GetVarValue(rightStr, context)
-		}
-	}
-	if rightValueStr, ok := rightValue.(string); ok {
-		rightValue = m.replaceMacro(rightValueStr, context)
+	rightValue, err := m.getRightValue(right, context)
+	if err != nil {
+		return false, err
 	}
 	return fn(leftValue, rightValue, context)
 }
 
-func (m *Dictionary) objectPropertyAssign(left string, right interface{}, context Context) bool {
+// 查找object.property形式的左值对应的对象
+func (m *Dictionary) lookupObjectProperty(left string, context Context) (Object, string, bool) {
 	parts := strings.Split(left, ".")
 	if len(parts) != 2 {
-		return false
+		return nil, "", false
 	}
-	var rightValue interface{} = right
-	if rightStr, ok := right.(string); ok {
-		if len(rightStr) > 1 && rightStr[0] == '$' {
-			rightValue, _ = m.GetVarValue(rightStr, context)
+	obj, ok := m.getObjectFromContext(parts[0], context)
+	if !ok {
+		if obj, ok = m.getObject(parts[0]); !ok {
+			return nil, "", false
 		}
 	}
-	if rightValueStr, ok := rightValue.(string); ok {
-		rightValue = m.replaceMacro(rightValueStr, context)
+	return obj, parts[1], true
+}
+
+func (m *Dictionary) objectPropertyAssign(left string, right interface{}, context Context) (bool, error) {
+	obj, property, ok := m.lookupObjectProperty(left, context)
+	if !ok {
+		return false, nil
+	}
+	if isReadOnlyObject(obj) {
+		return true, fmt.Errorf("object of %s is read-only", left)
+	}
+	rightValue, err := m.getRightValue(right, context)
+	if err != nil {
+		return true, err
+	}
+	if err := getExecState(context).checkValueSize(rightValue); err != nil {
+		return true, err
 	}
+	recordObjectUndo(obj, property, context)
+	obj.SetPropertyValue(property, rightValue, context)
+	return true, nil
+}
 
-	if obj, ok := m.getObjectFromContext(parts[0], context); ok {
-		obj.SetPropertyValue(parts[1], rightValue, context)
-		return true
+func (m *Dictionary) objectPropertyUnset(left string, context Context) (bool, error) {
+	obj, property, ok := m.lookupObjectProperty(left, context)
+	if !ok {
+		return false, nil
 	}
-	if obj, ok := m.getObject(parts[0]); ok {
-		obj.SetPropertyValue(parts[1], rightValue, context)
-		return true
+	if isReadOnlyObject(obj) {
+		return true, fmt.Errorf("object of %s is read-only", left)
+	}
+	deletable, ok := obj.(DeletableObject)
+	if !ok {
+		return true, fmt.Errorf("object of %s does not support unset", left)
+	}
+	recordObjectUndo(obj, property, context)
+	deletable.DeleteProperty(property, context)
+	return true, nil
+}
+
+func isReadOnlyObject(obj Object) bool {
+	ro, ok := obj.(ReadOnlyObject)
+	return ok && ro.ReadOnly()
+}
+
+// 在事务上下文中修改对象属性之前，记录对象的回滚函数
+func recordObjectUndo(obj Object, property string, context Context) {
+	if tx, ok := context.(*TxContext); ok {
+		if undoable, ok := obj.(UndoableObject); ok {
+			tx.RecordUndo(undoable.PropertyUndoHook(property, context))
+		}
 	}
-	return false
 }
 
 func (m *Dictionary) Assign(assignName string, left string, right interface{}, context Context) error {
+	return m.assign(assignName, nil, left, right, context, "", -1, "")
+}
+
+// 使用已获取的赋值运算函数fn进行赋值，groupName、nodeIndex和nodeID为赋值所在的表达式组和节点，用于通知赋值观察者，
+// nodeID不为空时错误被包装为*NodeError。除了不再按名称查找运算函数，其行为与表达式组中的赋值完全相同
+func (m *Dictionary) AssignWith(assignName string, fn AssignFunc, left string, right interface{}, context Context, groupName string, nodeIndex int, nodeID string) error {
+	if fn == nil {
+		return fmt.Errorf("assign func of %s is nil", assignName)
+	}
+	return wrapNodeError(m.assign(assignName, fn, left, right, context, groupName, nodeIndex, nodeID), groupName, nodeIndex, nodeID)
+}
+
+// 执行赋值并通知赋值观察者，fn为nil时按assignName查找赋值运算函数
+func (m *Dictionary) assign(assignName string, fn AssignFunc, left string, right interface{}, context Context, groupName string, nodeIndex int, nodeID string) error {
+	observers := m.getAssignObservers(context)
+	if len(observers) == 0 {
+		return m.doAssign(assignName, fn, left, right, context)
+	}
+	oldValue := m.observedValue(assignName, left, context)
+	if err := m.doAssign(assignName, fn, left, right, context); err != nil {
+		return err
+	}
+	event := &AssignEvent{
+		VarName:   left,
+		Operator:  assignName,
+		OldValue:  oldValue,
+		NewValue:  m.observedValue(assignName, left, context),
+		Group:     groupName,
+		NodeIndex: nodeIndex,
+		NodeID:    nodeID,
+	}
+	for _, observer := range observers {
+		observer(event, context)
+	}
+	return nil
+}
+
+func (m *Dictionary) doAssign(assignName string, fn AssignFunc, left string, right interface{}, context Context) error {
 	if assignName == "" {
 		return fmt.Errorf("assign name is empty")
 	}
 
-	if assignName == "=" && m.objectPropertyAssign(left, right, context) {
-		return nil
+	state := getExecState(context)
+	if err := state.countAssign(); err != nil {
+		return err
+	}
+	if assignName == "=" {
+		if ok, err := m.objectPropertyAssign(left, right, context); ok {
+			return err
+		}
+	} else if assignName == "unset" {
+		if ok, err := m.objectPropertyUnset(left, context); ok {
+			return err
+		}
 	}
 
-	fn, ok := m.getAssignFunc(assignName)
-	if !ok {
-		return fmt.Errorf("assign name %s not found", assignName)
+	if fn == nil {
+		var ok bool
+		if fn, ok = m.getAssignFunc(assignName); !ok {
+			return fmt.Errorf("assign name %s not found", assignName)
+		}
 	}
 	leftValue, _ := m.GetVarValue(left, context)
-	var rightValue interface{} = right
-	if rightStr, ok := right.(string); ok {
-		if len(rightStr) > 1 && rightStr[0] == '$' {
-			rightValue, _ = m.GetVarValue(rightStr, context)
-		}
+	rightValue, err := m.getRightValue(right, context)
+	if err != nil {
+		return err
+	}
+	_, typed := m.lookupVarDecl(left, context)
+	if !typed && !state.limitValueSize() {
+		return fn(left, leftValue, rightValue, context)
 	}
-	if rightValueStr, ok := rightValue.(string); ok {
-		rightValue = m.replaceMacro(rightValueStr, context)
+
+	// 声明了类型的变量，赋值结果转换为声明的类型; 内置的赋值运算符在分配内存之前检查执行限制，
+	// 这里再检查自定义赋值运算符的结果，超出执行限制或者转换失败时恢复原值
+	oldValue, existed := context.GetCtxData(left)
+	if err := fn(left, leftValue, rightValue, context); err != nil {
+		return err
 	}
-	return fn(left, leftValue, rightValue, context)
+	if newValue, ok := context.GetCtxData(left); ok {
+		coerced, err := m.coerceVarValue(left, newValue, context)
+		if err == nil {
+			err = state.checkValueSize(coerced)
+		}
+		if err != nil {
+			if existed {
+				context.SetCtxData(left, oldValue)
+			} else {
+				context.RemoveCtxData(left)
+			}
+			return err
+		}
+		if typed {
+			context.SetCtxData(left, coerced)
+		}
+	}
+	return nil
 }
 
 func (m *Dictionary) ListVars() []string {
-	m.varListLock.RLock()
-	defer m.varListLock.RUnlock()
+	defer m.rlock(&m.varListLock)()
 	var ret []string
 	for k := range m.varList {
 		ret = append(ret, k)
@@ -559,8 +809,7 @@ func (m *Dictionary) ListVars() []string {
 }
 
 func (m *Dictionary) ListObjects() []string {
-	m.objectListLock.RLock()
-	defer m.objectListLock.RUnlock()
+	defer m.rlock(&m.objectListLock)()
 	var ret []string
 	for k := range m.objectList {
 		ret = append(ret, k)
@@ -569,8 +818,7 @@ func (m *Dictionary) ListObjects() []string {
 }
 
 func (m *Dictionary) ListCompares() []string {
-	m.compareListLock.RLock()
-	defer m.compareListLock.RUnlock()
+	defer m.rlock(&m.compareListLock)()
 	var ret []string
 	for k := range m.compareList {
 		ret = append(ret, k)
@@ -579,8 +827,7 @@ func (m *Dictionary) ListCompares() []string {
 }
 
 func (m *Dictionary) ListAssigns() []string {
-	m.assignListLock.RLock()
-	defer m.assignListLock.RUnlock()
+	defer m.rlock(&m.assignListLock)()
 	var ret []string
 	for k := range m.assignList {
 		ret = append(ret, k)
@@ -606,31 +853,75 @@ type CompareExp struct {
 }
 
 type JsonExp struct {
+	// 原子操作的int64在32位平台上必须8字节对齐，放在第一个字段以保证对齐
+	counters       nodeCounters
 	compareExpList []*CompareExp
 	assignExpList  []*AssignExp
 	dict           *Dictionary
+	group          *JsonExpGroup
+	index          int
+	meta           NodeMeta
 }
 
+// 执行表达式节点，被禁用或不在生效时间内的节点不执行
 func (m *JsonExp) Execute(context Context) error {
+	if m.skipReason() != "" {
+		return nil
+	}
+	_, err := m.execute(context)
+	return err
+}
+
+// 节点被跳过的原因，不跳过时返回空字符串
+func (m *JsonExp) skipReason() string {
+	if !m.meta.conditional() {
+		return ""
+	}
+	ret := m.meta.skipReason(m.dict.Now())
+	if ret != "" {
+		atomic.AddInt64(&m.counters.skipped, 1)
+	}
+	return ret
+}
+
+// 执行表达式，返回条件是否成立
+func (m *JsonExp) execute(context Context) (bool, error) {
+	atomic.AddInt64(&m.counters.executed, 1)
+	matched, err := m.doExecute(context)
+	if matched {
+		atomic.AddInt64(&m.counters.matched, 1)
+	}
+	if err != nil {
+		atomic.AddInt64(&m.counters.errors, 1)
+	}
+	return matched, m.wrapError(err)
+}
+
+func (m *JsonExp) doExecute(context Context) (bool, error) {
 	for _, v := range m.compareExpList {
-		if ret, err := m.dict.Compare(v.CompareName, v.Left, v.Right, context); err != nil {
-			return nil
-		} else if !ret {
-			return nil
+		ret, err := m.dict.Compare(v.CompareName, v.Left, v.Right, context)
+		traceOperation(context, TraceEventCompare, v.Left, v.CompareName, v.Right, ret, err)
+		if err != nil || !ret {
+			return false, nil
 		}
 	}
 	for _, v := range m.assignExpList {
-		if err := m.dict.Assign(v.AssignName, v.Left, v.Right, context); err != nil {
-			return err
-		} else {
-			if breaked, ok := context.GetCtxData("$break"); ok {
-				if r, _ := GetIntValue(breaked); r == 1 {
-					break
-				}
-			}
+		err := m.dict.assign(v.AssignName, nil, v.Left, v.Right, context, m.groupName(), m.index, m.meta.ID)
+		traceOperation(context, TraceEventAssign, v.Left, v.AssignName, v.Right, err == nil, err)
+		if err != nil {
+			return true, err
+		} else if IsBreak(context) {
+			break
 		}
 	}
-	return nil
+	return true, nil
+}
+
+func (m *JsonExp) groupName() string {
+	if m.group == nil {
+		return ""
+	}
+	return m.group.name
 }
 
 func (m *JsonExp) GetCompareExpList() []*CompareExp {
@@ -674,9 +965,14 @@ func (m *JsonExp) GetAssignExpList() []*AssignExp {
 	}
 */
 type JsonExpGroup struct {
-	dict        *Dictionary
-	groupSource interface{}
-	group       []*JsonExp
+	name          string
+	dict          *Dictionary
+	groupSource   interface{}
+	group         []*JsonExp
+	transactional bool
+	limits        ExecutionLimits
+	limiters      *rateLimiterSet
+	varDecls      map[string]*VarDecl // 所在配置中声明的变量
 }
 
 func NewJsonExpGroup(dict *Dictionary, groupSource interface{}) (*JsonExpGroup, error) {
@@ -696,13 +992,27 @@ func (m *JsonExpGroup) parse() error {
 		return fmt.Errorf("invalid groupSource, not a slice")
 	}
 	group := m.groupSource.([]interface{})
+	ids := make(map[string]bool)
 	for _, nodeSource := range group {
+		jsonExp := &JsonExp{dict: m.dict, group: m, index: len(m.group), meta: defaultNodeMeta()}
+		if nodeObject, ok := nodeSource.(map[string]interface{}); ok {
+			meta, node, err := parseNodeObject(nodeObject, m.dict.Now())
+			if err != nil {
+				return fmt.Errorf("invalid groupSource, node %d, %s", jsonExp.index, err.Error())
+			}
+			if meta.ID != "" {
+				if ids[meta.ID] {
+					return fmt.Errorf("invalid groupSource, duplicate node id %s", meta.ID)
+				}
+				ids[meta.ID] = true
+			}
+			jsonExp.meta, nodeSource = meta, node
+		}
 		v := reflect.ValueOf(nodeSource)
 		if v.Kind() != reflect.Slice {
-			return fmt.Errorf("invalid groupSource, exp node is not a slice")
+			return fmt.Errorf("invalid groupSource, exp node is not a slice or an object")
 		}
 		node := nodeSource.([]interface{})
-		jsonExp := &JsonExp{dict: m.dict}
 		for i, expSource := range node {
 			v := reflect.ValueOf(expSource)
 			if v.Kind() != reflect.Slice {
@@ -779,22 +1089,95 @@ func (m *JsonExpGroup) parse() error {
 	return nil
 }
 
+// 表达式组的名称，即在Configuration中的键
+func (m *JsonExpGroup) Name() string {
+	return m.name
+}
+
+func (m *JsonExpGroup) SetName(name string) {
+	m.name = name
+}
+
+// 设置是否以事务方式执行每个表达式节点，开启后节点执行失败时，该节点已做的写入被回滚
+func (m *JsonExpGroup) SetTransactional(transactional bool) {
+	m.transactional = transactional
+}
+
+func (m *JsonExpGroup) Transactional() bool {
+	return m.transactional
+}
+
 // 执行表达式组
-func (m *JsonExpGroup) Execute(context Context) error {
+func (m *JsonExpGroup) Execute(jsonexpContext Context) error {
+	if m.limits != (ExecutionLimits{}) {
+		return m.ExecuteContext(context.Background(), jsonexpContext)
+	}
+	return m.execute(jsonexpContext)
+}
+
+// 表达式组执行前对上下文的准备: 上下文中没有$rand时生成$rand
+func PrepareContext(context Context) {
 	if context != nil {
 		if _, ok := context.GetCtxData("$rand"); !ok {
 			context.SetCtxData("$rand", rand.Intn(100)+1)
 		}
 	}
-	for _, jsonExp := range m.group {
-		if err := jsonExp.Execute(context); err != nil {
-			return err
+}
+
+// 上下文中的$break是否为1，为1时终止表达式组的执行
+func IsBreak(context Context) bool {
+	if context == nil {
+		return false
+	}
+	if breaked, ok := context.GetCtxData("$break"); ok {
+		if r, _ := GetIntValue(breaked); r == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *JsonExpGroup) execute(context Context) error {
+	PrepareContext(context)
+	defer m.limiters.enter(context)()
+	defer SetContextVarDecls(context, m.varDecls)()
+	var tx *TxContext
+	if m.transactional && context != nil {
+		if ctxTx, ok := context.(*TxContext); ok {
+			tx = ctxTx
 		} else {
-			if breaked, ok := context.GetCtxData("$break"); ok {
-				if r, _ := GetIntValue(breaked); r == 1 {
-					break
-				}
+			tx = NewTxContext(context)
+			defer tx.Commit()
+		}
+		context = tx
+	}
+	state := getExecState(context)
+	for i, jsonExp := range m.group {
+		if reason := jsonExp.skipReason(); reason != "" {
+			if nodeEvent := traceNode(context, i, jsonExp.meta.ID); nodeEvent != nil {
+				nodeEvent.Skipped = reason
+			}
+			continue
+		}
+		if err := state.enterNode(); err != nil {
+			return err
+		}
+		savepoint := 0
+		if tx != nil {
+			savepoint = tx.Savepoint()
+		}
+		nodeEvent := traceNode(context, i, jsonExp.meta.ID)
+		matched, err := jsonExp.execute(context)
+		if nodeEvent != nil {
+			nodeEvent.Result = matched
+		}
+		if err != nil {
+			if tx != nil {
+				tx.RollbackTo(savepoint)
 			}
+			return err
+		} else if IsBreak(context) {
+			break
 		}
 	}
 	return nil
@@ -810,45 +1193,91 @@ type Configuration struct {
 	dict          *Dictionary
 	nameValues    map[string]interface{}
 	jsonExpGroups map[string]*JsonExpGroup
+	varDecls      map[string]*VarDecl
+	limiters      *rateLimiterSet
 }
 
-// 传入json,创建一个Configuration对象
+// 传入json,创建一个Configuration对象，json中可以包含注释(//和/* */)以及尾随逗号
 func NewConfiguration(jsonSource []byte, dict *Dictionary) (*Configuration, error) {
 	if len(jsonSource) == 0 || dict == nil {
 		return nil, fmt.Errorf("invalid jsonSource or dict")
 	}
-	mp := make(map[string]interface{})
-	if err := json.Unmarshal(jsonSource, &mp); err != nil {
-		return nil, fmt.Errorf("unmarshal json fail, %s", err.Error())
+	mp, err := unmarshalJSONC(jsonSource)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal json fail, %w", err)
 	}
+	return newConfigurationFromMap(jsonSource, mp, dict)
+}
+
+func newConfigurationFromMap(jsonSource []byte, mp map[string]interface{}, dict *Dictionary) (*Configuration, error) {
 	ret := &Configuration{
 		jsonSource:    jsonSource,
 		dict:          dict,
 		nameValues:    make(map[string]interface{}),
 		jsonExpGroups: make(map[string]*JsonExpGroup),
+		varDecls:      make(map[string]*VarDecl),
+	}
+	if varsSource, ok := mp[ConfigurationVarsKey]; ok {
+		decls, err := parseVarDecls(varsSource)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range decls {
+			ret.varDecls[decl.Name] = decl
+		}
+		delete(mp, ConfigurationVarsKey)
+	}
+	if bucketsSource, ok := mp[ConfigurationBucketsKey]; ok {
+		limiters, err := parseBucketDecls(bucketsSource)
+		if err != nil {
+			return nil, err
+		}
+		ret.limiters = &rateLimiterSet{limiters: limiters}
+		delete(mp, ConfigurationBucketsKey)
 	}
 	for k, v := range mp {
 		if group, err := NewJsonExpGroup(dict, v); err == nil {
+			if err := group.checkVarDecls(ret.varDecls); err != nil {
+				return nil, fmt.Errorf("group %s, %s", k, err.Error())
+			}
+			if err := group.checkMacros(); err != nil {
+				return nil, fmt.Errorf("group %s, %s", k, err.Error())
+			}
+			var limiters map[string]*rateLimiter
+			if ret.limiters != nil {
+				limiters = ret.limiters.limiters
+			}
+			if err := group.checkBuckets(limiters); err != nil {
+				return nil, fmt.Errorf("group %s, %s", k, err.Error())
+			}
+			group.limiters = ret.limiters
+			group.varDecls = ret.varDecls
+			group.name = k
 			ret.jsonExpGroups[k] = group
+		} else if hasNodeObject(v) {
+			return nil, fmt.Errorf("group %s, %s", k, err.Error())
 		} else {
 			ret.nameValues[k] = v
 		}
 	}
+	if err := ret.checkNameValues(); err != nil {
+		return nil, err
+	}
 	return ret, nil
 }
 
-// 获取键值
+// 获取配置中声明的变量
+func (m *Configuration) GetVarDecl(varName string) (*VarDecl, bool) {
+	ret, ok := m.varDecls[varName]
+	return ret, ok
+}
+
+// 获取键值，key可以是以.分隔的路径，字符串中的环境变量和宏被替换，见LookupNameValue
 func (m *Configuration) GetNameValue(key string, context Context) (interface{}, bool) {
-	ret, ok := m.nameValues[key]
-	if !ok {
-		return nil, false
-	}
-	if retStr, ok := ret.(string); ok {
-		if len(retStr) > 1 && retStr[0] == '$' {
-			if retValue, err := m.dict.GetVarValue(retStr, context); err == nil {
-				return retValue, true
-			}
-		}
+	ret, err := m.LookupNameValue(key, context)
+	if err != nil {
+		// 替换失败时(如宏中的管道函数出错)返回原始值
+		return lookupNameValuePath(m.nameValues, key)
 	}
 	return ret, true
 }
@@ -861,3 +1290,22 @@ func (m *Configuration) GetJsonExpGroup(key string) (*JsonExpGroup, bool) {
 	}
 	return ret, true
 }
+
+// 配置中所有JSON表达式组的名称，按名称排序
+func (m *Configuration) ListJsonExpGroups() []string {
+	ret := make([]string, 0, len(m.jsonExpGroups))
+	for k := range m.jsonExpGroups {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// 配置中所有的键值(未求值的原始值)
+func (m *Configuration) NameValues() map[string]interface{} {
+	ret := make(map[string]interface{}, len(m.nameValues))
+	for k, v := range m.nameValues {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+const maxInt = int(^uint(0) >> 1)
+
+// 上下文中保存执行状态的键
+const ContextKeyExecState = "__JSONEXP_EXEC_STATE__"
+
+var (
+	ErrorNodeLimitExceeded   = errors.New("jsonexp: node limit exceeded")
+	ErrorAssignLimitExceeded = errors.New("jsonexp: assignment limit exceeded")
+	ErrorStringSizeExceeded  = errors.New("jsonexp: string size limit exceeded")
+	ErrorListSizeExceeded    = errors.New("jsonexp: list size limit exceeded")
+)
+
+// 执行限制，0表示不限制
+type ExecutionLimits struct {
+	MaxNodes       int // 一次执行中最多执行的表达式节点数
+	MaxAssignments int // 一次执行中最多执行的赋值次数
+	MaxStringSize  int // 赋值运算符产生的字符串的最大长度(字节)
+	MaxListSize    int // 赋值运算符产生的列表的最大长度
+}
+
+// 一次执行的状态
+type execState struct {
+	ctx     context.Context
+	limits  ExecutionLimits
+	nodes   int
+	assigns int
+}
+
+func getExecState(jsonexpContext Context) *execState {
+	if jsonexpContext == nil {
+		return nil
+	}
+	if v, ok := jsonexpContext.GetCtxData(ContextKeyExecState); ok {
+		if ret, ok := v.(*execState); ok {
+			return ret
+		}
+	}
+	return nil
+}
+
+// 返回当前执行的context.Context，VarFunc等可以通过它感知取消和超时
+func GoContext(jsonexpContext Context) context.Context {
+	if state := getExecState(jsonexpContext); state != nil && state.ctx != nil {
+		return state.ctx
+	}
+	return context.Background()
+}
+
+func (m *execState) enterNode() error {
+	if m == nil {
+		return nil
+	}
+	if m.ctx != nil {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	m.nodes++
+	if m.limits.MaxNodes > 0 && m.nodes > m.limits.MaxNodes {
+		return ErrorNodeLimitExceeded
+	}
+	return nil
+}
+
+func (m *execState) countAssign() error {
+	if m == nil {
+		return nil
+	}
+	m.assigns++
+	if m.limits.MaxAssignments > 0 && m.assigns > m.limits.MaxAssignments {
+		return ErrorAssignLimitExceeded
+	}
+	return nil
+}
+
+func (m *execState) limitValueSize() bool {
+	return m != nil && (m.limits.MaxStringSize > 0 || m.limits.MaxListSize > 0)
+}
+
+func (m *execState) checkStringSize(size int) error {
+	if m != nil && m.limits.MaxStringSize > 0 && size > m.limits.MaxStringSize {
+		return ErrorStringSizeExceeded
+	}
+	return nil
+}
+
+// 检查size字节的字符串重复n次的长度，先做除法避免乘法溢出
+func (m *execState) checkRepeatSize(size int, n int64) error {
+	if size == 0 || n == 0 {
+		return nil
+	}
+	if n > int64(maxInt/size) {
+		return fmt.Errorf("string too large, %d * %d", size, n)
+	}
+	if m != nil && m.limits.MaxStringSize > 0 && int64(size) > int64(m.limits.MaxStringSize)/n {
+		return ErrorStringSizeExceeded
+	}
+	return nil
+}
+
+func (m *execState) checkListSize(size int) error {
+	if m != nil && m.limits.MaxListSize > 0 && size > m.limits.MaxListSize {
+		return ErrorListSizeExceeded
+	}
+	return nil
+}
+
+func (m *execState) checkValueSize(v interface{}) error {
+	if m == nil || v == nil {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		return m.checkStringSize(len(s))
+	}
+	if m.limits.MaxListSize > 0 {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+			return m.checkListSize(rv.Len())
+		}
+	}
+	return nil
+}
+
+// 设置表达式组的执行限制
+func (m *JsonExpGroup) SetLimits(limits ExecutionLimits) {
+	m.limits = limits
+}
+
+func (m *JsonExpGroup) Limits() ExecutionLimits {
+	return m.limits
+}
+
+// 执行表达式组，ctx取消或超时时终止执行并返回ctx.Err()。
+// 执行状态(包括执行限制的计数)保存在jsonexpContext中，同一个jsonexpContext不能同时用于多个执行
+func (m *JsonExpGroup) ExecuteContext(ctx context.Context, jsonexpContext Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if jsonexpContext == nil {
+		return m.execute(jsonexpContext)
+	}
+	old, hasOld := jsonexpContext.GetCtxData(ContextKeyExecState)
+	jsonexpContext.SetCtxData(ContextKeyExecState, &execState{ctx: ctx, limits: m.limits})
+	defer func() {
+		if hasOld {
+			jsonexpContext.SetCtxData(ContextKeyExecState, old)
+		} else {
+			jsonexpContext.RemoveCtxData(ContextKeyExecState)
+		}
+	}()
+	return m.execute(jsonexpContext)
+}
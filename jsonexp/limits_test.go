@@ -0,0 +1,87 @@
+package jsonexp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestExecutionLimits(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$s", nil)
+	dict.RegisterVar("$n", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$s", "=", "abcdefgh"]],
+			[["$n", "=", 1]],
+			[["$n", "+=", 1]],
+			[["$n", "+=", 1]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+
+	g.SetLimits(ExecutionLimits{MaxNodes: 2})
+	if err := g.Execute(&goutil.DefaultContext{}); err != ErrorNodeLimitExceeded {
+		t.Fatalf("expect node limit error, got %v", err)
+	}
+	g.SetLimits(ExecutionLimits{MaxAssignments: 3})
+	if err := g.Execute(&goutil.DefaultContext{}); err != ErrorAssignLimitExceeded {
+		t.Fatalf("expect assignment limit error, got %v", err)
+	}
+	g.SetLimits(ExecutionLimits{MaxStringSize: 4})
+	ctx := &goutil.DefaultContext{}
+	if err := g.Execute(ctx); err != ErrorStringSizeExceeded {
+		t.Fatalf("expect string size error, got %v", err)
+	}
+	if _, ok := ctx.GetCtxData("$s"); ok {
+		t.Fatalf("oversized value should not be assigned")
+	}
+	if _, ok := ctx.GetCtxData(ContextKeyExecState); ok {
+		t.Fatalf("exec state should be removed after execution")
+	}
+
+	// 内置的赋值运算符在分配内存前检查
+	ctx = &goutil.DefaultContext{}
+	ctx.SetCtxData("$s", "ab")
+	ctx.SetCtxData(ContextKeyExecState, &execState{limits: ExecutionLimits{MaxStringSize: 100, MaxListSize: 2}})
+	if err := dict.Assign("*=", "$s", 1000000, ctx); err != ErrorStringSizeExceeded {
+		t.Fatalf("expect string size error, got %v", err)
+	}
+	long := strings.Repeat("x", 100)
+	if err := AddAssign("$s", long, long, ctx); err != ErrorStringSizeExceeded {
+		t.Fatalf("expect string size error, got %v", err)
+	}
+	if err := Assign("$s", nil, []interface{}{1, 2, 3}, ctx); err != ErrorListSizeExceeded {
+		t.Fatalf("expect list size error, got %v", err)
+	}
+	if err := ConditionalAssign("$t", nil, long+long, ctx); err != ErrorStringSizeExceeded {
+		t.Fatalf("expect string size error, got %v", err)
+	}
+	// 重复次数很大时不能因为乘法溢出绕过检查
+	if err := MulAssign("$s", "ab", int64(1)<<62, ctx); err == nil {
+		t.Fatalf("expect error for overflowing repeat")
+	}
+	if err := MulAssign("$s", "ab", int64(1)<<62, &goutil.DefaultContext{}); err == nil {
+		t.Fatalf("expect error for overflowing repeat without limits")
+	}
+	if v, _ := ctx.GetCtxData("$s"); v != "ab" {
+		t.Fatalf("$s = %v", v)
+	}
+
+	g.SetLimits(ExecutionLimits{})
+	c, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-c.Done()
+	if err := g.ExecuteContext(c, &goutil.DefaultContext{}); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if err := g.ExecuteContext(context.Background(), &goutil.DefaultContext{}); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	PipelineFnContains = "contains"
+	PipelineFnIndex    = "index"
+)
+
+// 将v转换为列表: 数组原样返回(复制)，字符串视为逗号分隔的集合，nil和空字符串为空列表
+func GetListValue(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return []interface{}{}, true
+	}
+	if s, ok := v.(string); ok {
+		if s == "" {
+			return []interface{}{}, true
+		}
+		parts := strings.Split(s, ",")
+		ret := make([]interface{}, len(parts))
+		for i, p := range parts {
+			ret[i] = p
+		}
+		return ret, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		ret := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ret[i] = rv.Index(i).Interface()
+		}
+		return ret, true
+	}
+	if _, ok := GetStringValue(v); ok {
+		return []interface{}{v}, true
+	}
+	return nil, false
+}
+
+// 将v转换为字符串列表，用于集合运算符的比较
+func getStringList(v interface{}) ([]string, bool) {
+	list, ok := GetListValue(v)
+	if !ok {
+		return nil, false
+	}
+	ret := make([]string, len(list))
+	for i, item := range list {
+		ret[i] = listItemKey(item)
+	}
+	return ret, true
+}
+
+// 列表元素比较时使用的键。数值使用最短的十进制形式(1.0为"1"，1.5为"1.5")，因此JSON中的1与"1"相等
+func listItemKey(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.String:
+		return rv.String()
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 32)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10)
+	}
+	return fmt.Sprint(v)
+}
+
+func listIndexOf(list []interface{}, item interface{}) int {
+	key := listItemKey(item)
+	for i, v := range list {
+		if listItemKey(v) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func dedupeList(list []interface{}) []interface{} {
+	ret := make([]interface{}, 0, len(list))
+	seen := make(map[string]struct{}, len(list))
+	for _, v := range list {
+		key := listItemKey(v)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// 将R中的元素追加到列表末尾
+var AppendAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	list, ok := GetListValue(lValue)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	items, ok := GetListValue(R)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	if err := getExecState(ret).checkListSize(len(list) + len(items)); err != nil {
+		return err
+	}
+	ret.SetCtxData(L, append(list, items...))
+	return nil
+}
+
+// 从列表中删除R中的元素
+var RemoveAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	list, ok := GetListValue(lValue)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	items, ok := GetListValue(R)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	removed := make(map[string]struct{}, len(items))
+	for _, v := range items {
+		removed[listItemKey(v)] = struct{}{}
+	}
+	newList := make([]interface{}, 0, len(list))
+	for _, v := range list {
+		if _, ok := removed[listItemKey(v)]; !ok {
+			newList = append(newList, v)
+		}
+	}
+	ret.SetCtxData(L, newList)
+	return nil
+}
+
+// 列表与R的并集，结果去重
+var UnionAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	list, ok := GetListValue(lValue)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	items, ok := GetListValue(R)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	if err := getExecState(ret).checkListSize(len(list) + len(items)); err != nil {
+		return err
+	}
+	ret.SetCtxData(L, dedupeList(append(list, items...)))
+	return nil
+}
+
+// 列表去重，忽略R
+var DedupeAssign = func(L string, lValue interface{}, R interface{}, ret Context) error {
+	if ret == nil {
+		return fmt.Errorf("param ret is nil")
+	}
+	list, ok := GetListValue(lValue)
+	if !ok {
+		return fmt.Errorf("invalid operand")
+	}
+	ret.SetCtxData(L, dedupeList(list))
+	return nil
+}
+
+// contains:item 列表中包含item时返回1，否则返回0
+func pipeFnContains(input interface{}, arg string, context Context) (interface{}, error) {
+	list, ok := GetListValue(input)
+	if !ok {
+		return nil, fmt.Errorf("no list value")
+	}
+	if listIndexOf(list, arg) >= 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// index:item 返回item在列表中的位置(从0开始)，不存在时返回-1
+func pipeFnIndex(input interface{}, arg string, context Context) (interface{}, error) {
+	list, ok := GetListValue(input)
+	if !ok {
+		return nil, fmt.Errorf("no list value")
+	}
+	return listIndexOf(list, arg), nil
+}
@@ -0,0 +1,97 @@
+package jsonexp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestListAssign(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$tags", nil)
+	dict.RegisterVar("$blocked", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$tags", "append", "vip"]],
+			[["$tags", "append", ["sport", "news", "vip"]]],
+			[["$tags", "dedupe", ""]],
+			[["$tags", "remove", "news"]],
+			[["$tags", "union", "music,vip"]],
+			[
+				["$tags|len", "=", 3],
+				["$tags|contains:sport", "=", 1],
+				["$tags|index:music", "=", 2],
+				["$tags", "has", ["vip", "sport"]],
+				["$tags", "any", "game,music"],
+				["$tags", "none", ["game"]],
+				["$blocked", "=", "yes"]
+			]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	tags, _ := ctx.GetCtxData("$tags")
+	if !reflect.DeepEqual(tags, []interface{}{"vip", "sport", "music"}) {
+		t.Fatalf("$tags = %v", tags)
+	}
+	if v, _ := ctx.GetCtxData("$blocked"); v != "yes" {
+		t.Fatalf("list compares fail, $tags = %v", tags)
+	}
+	if ret, _ := In("vip", tags, nil); !ret {
+		t.Fatalf("in with list fail")
+	}
+}
+
+// 列表来自JSON中的数值时，元素与其字符串形式相等
+func TestNumericListItems(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$ids", nil)
+	dict.RegisterVar("$ok", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$ids", "=", [1, 2, 2.5]]],
+			[["$ids", "union", ["1", "3"]]],
+			[["$ids", "append", [3]]],
+			[["$ids", "dedupe", ""]],
+			[
+				["$ids|contains:1", "=", 1],
+				["$ids|index:2.5", "=", 2],
+				["$ids", "has", "1"],
+				["$ids", "has", [2, "3"]],
+				["$ids", "none", [1.5]],
+				["$ids", "=", ["1", "2", "2.5", "3"]],
+				["$ok", "=", 1]
+			]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	ids, _ := ctx.GetCtxData("$ids")
+	if !reflect.DeepEqual(ids, []interface{}{float64(1), float64(2), 2.5, "3"}) {
+		t.Fatalf("$ids = %#v", ids)
+	}
+	if _, ok := ctx.GetCtxData("$ok"); !ok {
+		t.Fatalf("numeric list compares fail, $ids = %v", ids)
+	}
+	if ret, _ := In(float64(2), []interface{}{"1", "2"}, nil); !ret {
+		t.Fatalf("2 should be in [\"1\", \"2\"]")
+	}
+	for _, v := range []interface{}{int64(7), 7, uint8(7), float32(7), 7.0, "7"} {
+		if key := listItemKey(v); key != "7" {
+			t.Fatalf("listItemKey(%#v) = %s", v, key)
+		}
+	}
+}
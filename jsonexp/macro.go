@@ -0,0 +1,285 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 宏的格式，只有{{$开始宏，其他的{{是普通文本:
+//
+//	{{$var}}                  变量的值，变量不存在时保留宏的原文
+//	{{$var|lower|md5}}        管道
+//	{{$var|default:unknown}}  变量不存在、为空时使用默认值，必须是最后一段
+//	{{$price|%.2f}}           按printf格式输出
+//	{{$ts|date:2006-01-02}}   按日期格式输出，变量值可以是日期时间字符串或者unix时间戳
+//	\{{$                      输出字面量{{$
+const (
+	macroBegin         = "{{$"
+	macroEnd           = "}}"
+	macroEscape        = `\{{$`
+	macroDefaultPrefix = "default:"
+	macroDatePrefix    = "date:"
+	macroFormatPrefix  = "%"
+)
+
+type macroPart struct {
+	text       string // 普通文本
+	isMacro    bool
+	source     string // 宏的原始文本，用于错误信息
+	varName    string
+	pipe       *pipeline
+	hasDefault bool
+	dft        string
+	format     string // printf格式
+	dateLayout string
+}
+
+// 预解析的宏模板
+type macroTemplate struct {
+	parts []*macroPart
+}
+
+func hasMacro(s string) bool {
+	return strings.Contains(s, macroBegin)
+}
+
+func parseMacroTemplate(s string, dict *Dictionary) (*macroTemplate, error) {
+	ret := &macroTemplate{}
+	var text strings.Builder
+	for len(s) > 0 {
+		if strings.HasPrefix(s, macroEscape) {
+			text.WriteString(macroBegin)
+			s = s[len(macroEscape):]
+			continue
+		}
+		if !strings.HasPrefix(s, macroBegin) {
+			text.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		end := strings.Index(s, macroEnd)
+		if end < 0 {
+			// 没有结束的}}，不是宏
+			text.WriteString(s)
+			break
+		}
+		part, err := parseMacro(s[len(macroBegin)-1:end], dict)
+		if err != nil {
+			return nil, err
+		}
+		if text.Len() > 0 {
+			ret.parts = append(ret.parts, &macroPart{text: text.String()})
+			text.Reset()
+		}
+		ret.parts = append(ret.parts, part)
+		s = s[end+len(macroEnd):]
+	}
+	if text.Len() > 0 {
+		ret.parts = append(ret.parts, &macroPart{text: text.String()})
+	}
+	return ret, nil
+}
+
+func parseMacro(source string, dict *Dictionary) (*macroPart, error) {
+	ret := &macroPart{isMacro: true, source: source}
+	segments := strings.Split(strings.TrimSpace(source), "|")
+	if last := strings.TrimSpace(segments[len(segments)-1]); len(segments) > 1 && strings.HasPrefix(last, macroDefaultPrefix) {
+		ret.hasDefault = true
+		ret.dft = last[len(macroDefaultPrefix):]
+		segments = segments[:len(segments)-1]
+	}
+	ret.varName = strings.TrimSpace(segments[0])
+	if len(ret.varName) <= 1 || ret.varName[0] != '$' {
+		return nil, fmt.Errorf("invalid macro {{%s}}, variable name must start with $", source)
+	}
+	ret.pipe = &pipeline{OriginName: ret.varName}
+	for i, seg := range segments[1:] {
+		last := i == len(segments)-2
+		seg = strings.TrimSpace(seg)
+		switch {
+		case strings.HasPrefix(seg, macroFormatPrefix):
+			if !last {
+				return nil, fmt.Errorf("invalid macro {{%s}}, format must be the last one", source)
+			}
+			ret.format = seg
+		case strings.HasPrefix(seg, macroDatePrefix):
+			if !last {
+				return nil, fmt.Errorf("invalid macro {{%s}}, date format must be the last one", source)
+			}
+			ret.dateLayout = seg[len(macroDatePrefix):]
+			if ret.dateLayout == "" {
+				return nil, fmt.Errorf("invalid macro {{%s}}, empty date format", source)
+			}
+		default:
+			fn := dict.resolvePipeFunction(seg)
+			if fn == nil {
+				return nil, fmt.Errorf("invalid macro {{%s}}, pipe function %s not found", source, seg)
+			}
+			ret.pipe.FunctionList = append(ret.pipe.FunctionList, fn)
+		}
+	}
+	return ret, nil
+}
+
+func (m *macroPart) formatValue(v interface{}, dict *Dictionary) (string, error) {
+	if m.dateLayout != "" {
+		t, ok := GetDateTimeValue(v, dict.Now())
+		if !ok {
+			return "", fmt.Errorf("macro {{%s}}, %v is not a datetime", m.source, v)
+		}
+		return t.Format(m.dateLayout), nil
+	}
+	if m.format != "" {
+		switch m.format[len(m.format)-1] {
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			if f, ok := GetFloatValue(v); ok {
+				return fmt.Sprintf(m.format, f), nil
+			}
+		case 'd', 'x', 'X', 'o', 'b', 'c':
+			if i, ok := GetIntValue(v); ok {
+				return fmt.Sprintf(m.format, i), nil
+			}
+		default:
+			return fmt.Sprintf(m.format, v), nil
+		}
+		return "", fmt.Errorf("macro {{%s}}, %v is not a number", m.source, v)
+	}
+	if ret, ok := GetStringValue(v); ok {
+		return ret, nil
+	}
+	return "", fmt.Errorf("macro {{%s}}, invalid value %v", m.source, v)
+}
+
+// 宏的原文
+func (m *macroPart) String() string {
+	return "{{" + m.source + "}}"
+}
+
+// 变量不存在且没有默认值时保留宏的原文；没有管道和格式的宏，值不能转换为字符串时也保留原文
+func (m *macroPart) expand(dict *Dictionary, context Context) (string, error) {
+	v, err := dict.getOriginVarValue(m.varName, context)
+	if err != nil {
+		if m.hasDefault {
+			return m.dft, nil
+		}
+		return m.String(), nil
+	}
+	if len(m.pipe.FunctionList) > 0 {
+		if v, err = m.pipe.Execute(v, context); err != nil {
+			if m.hasDefault {
+				return m.dft, nil
+			}
+			return "", fmt.Errorf("macro %s, %s", m.String(), err.Error())
+		}
+	}
+	if m.hasDefault {
+		if s, ok := v.(string); v == nil || ok && s == "" {
+			return m.dft, nil
+		}
+	}
+	if m.format == "" && m.dateLayout == "" && len(m.pipe.FunctionList) == 0 {
+		if ret, ok := GetStringValue(v); ok {
+			return ret, nil
+		}
+		return m.String(), nil
+	}
+	if v == nil && m.format == "" && m.dateLayout == "" {
+		return "", nil
+	}
+	return m.formatValue(v, dict)
+}
+
+func (m *macroTemplate) Execute(dict *Dictionary, context Context) (string, error) {
+	if len(m.parts) == 1 && !m.parts[0].isMacro {
+		return m.parts[0].text, nil
+	}
+	var sb strings.Builder
+	for _, part := range m.parts {
+		if !part.isMacro {
+			sb.WriteString(part.text)
+			continue
+		}
+		s, err := part.expand(dict, context)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s)
+	}
+	return sb.String(), nil
+}
+
+// 获取预解析的宏模板，cache为true时缓存解析结果。
+// 只缓存来自配置中的常量字符串，避免变量值导致缓存无限增长
+func (m *Dictionary) getMacroTemplate(s string, cache bool) (*macroTemplate, error) {
+	if v, ok := m.macroTemplates.Load(s); ok {
+		return v.(*macroTemplate), nil
+	}
+	ret, err := parseMacroTemplate(s, m)
+	if err != nil {
+		return nil, err
+	}
+	if cache {
+		m.macroTemplates.Store(s, ret)
+	}
+	return ret, nil
+}
+
+// 宏替换, cache为true表示s来自配置中的常量，其解析结果可以缓存
+func (m *Dictionary) replaceMacro(s string, cache bool, context Context) (string, error) {
+	if !hasMacro(s) {
+		return s, nil
+	}
+	tpl, err := m.getMacroTemplate(s, cache)
+	if err != nil {
+		return "", err
+	}
+	return tpl.Execute(m, context)
+}
+
+// 对字符串进行宏替换
+func (m *Dictionary) ExpandMacro(s string, context Context) (string, error) {
+	return m.replaceMacro(s, false, context)
+}
+
+// 获取右值: 以$开头的字符串视为变量，配置中的常量字符串中的宏被替换。
+// 变量的值是运行时的数据，其中的宏不被替换，避免通过输入读取$env等变量
+func (m *Dictionary) getRightValue(right interface{}, context Context) (interface{}, error) {
+	rightStr, ok := right.(string)
+	if !ok {
+		return right, nil
+	}
+	if len(rightStr) > 1 && rightStr[0] == '$' {
+		rightValue, _ := m.GetVarValue(rightStr, context)
+		return rightValue, nil
+	}
+	return m.replaceMacro(rightStr, true, context)
+}
+
+// 检查表达式组中常量右值的宏语法
+func (m *JsonExpGroup) checkMacros() error {
+	check := func(exp *JsonExp, right interface{}) error {
+		if s, ok := right.(string); ok && !(len(s) > 1 && s[0] == '$') && hasMacro(s) {
+			if _, err := m.dict.getMacroTemplate(s, true); err != nil {
+				return fmt.Errorf("node %s, %s", exp.label(), err.Error())
+			}
+		}
+		return nil
+	}
+	for _, exp := range m.group {
+		for _, v := range exp.compareExpList {
+			if err := check(exp, v.Right); err != nil {
+				return err
+			}
+		}
+		for _, v := range exp.assignExpList {
+			if err := check(exp, v.Right); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
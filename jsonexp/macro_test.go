@@ -0,0 +1,76 @@
+package jsonexp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestMacro(t *testing.T) {
+	dict := NewDictionary()
+	dict.SetClock(func() time.Time { return time.Date(2021, 11, 23, 10, 0, 0, 0, time.Local) })
+	dict.RegisterVar("$ua", nil)
+	dict.RegisterVar("$city", nil)
+	dict.RegisterVar("$price", nil)
+	dict.RegisterVar("$ts", nil)
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$ua", "Chrome")
+	ctx.SetCtxData("$price", 3)
+	ctx.SetCtxData("$ts", "2021-11-20 08:00:00")
+
+	cases := map[string]string{
+		"ua: {{$ua}}":                       "ua: Chrome",
+		"ua: {{$ua | lower }}":              "ua: chrome",
+		"{{$ua|lower|len}}":                 "6",
+		"city: {{$city|default:unknown}}":   "city: unknown",
+		"city: {{$nocity|default:unknown}}": "city: unknown",
+		"{{$ua|default:none}}":              "Chrome",
+		"{{$ua|contains:-1|default:x}}":     "0",
+		"{{$nocity}} {{ title }} {{$ua":     "{{$nocity}} {{ title }} {{$ua",
+		"{{$price|%.2f}}":                   "3.00",
+		"{{$price|%03d}}":                   "003",
+		"{{$ts|date:2006/01/02}}":           "2021/11/20",
+		`literal \{{$ua}} {{$ua}}`:          "literal {{$ua}} Chrome",
+		`\{{ title }}`:                      `\{{ title }}`,
+		"no macro":                          "no macro",
+		"{{$ua}}-{{$city}}-{{$price}}":      "Chrome--3",
+	}
+	for src, want := range cases {
+		got, err := dict.ExpandMacro(src, ctx)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err.Error())
+		}
+		if got != want {
+			t.Fatalf("%s: got %s, want %s", src, got, want)
+		}
+	}
+
+	for _, src := range []string{"{{$ua|nofunc}}", "{{$ua|%d|lower}}", "{{$ua|default:x|lower}}", "{{$ua|date:}}"} {
+		if _, err := dict.ExpandMacro(src, ctx); err == nil {
+			t.Fatalf("%s: expect error", src)
+		}
+	}
+
+	if _, err := NewConfiguration([]byte(`{"g": [[["$ua", "=", "{{$ua|nofunc}}"]]]}`), dict); err == nil {
+		t.Fatalf("expect macro error when loading configuration")
+	}
+
+	// 运行时的变量值中的宏不被替换
+	dict.RegisterVar("$in", nil)
+	dict.RegisterVar("$out", nil)
+	cfg, err := NewConfiguration([]byte(`{"g": [[["$out", "=", "$in"]]]}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	for _, in := range []string{"a {{ b", "{{$ua}}", "{{$ua"} {
+		ctx.SetCtxData("$in", in)
+		if err := g.Execute(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := ctx.GetCtxData("$out"); v != in {
+			t.Fatalf("$out = %v, want %s", v, in)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 键值的读取:
+//
+//	GetNameValue("db.master.host", ctx)   按路径读取嵌套对象的属性，数组使用下标，如servers.0.host
+//	"host": "${DB_HOST}"                   环境变量，通过$env对象读取，受Dictionary.SetEnvAllowlist限制
+//	"host": "${DB_HOST:-127.0.0.1}"        环境变量不存在或为空时使用默认值
+//	"url": "http://{{$host}}/{{$path}}"    宏，与右值中的宏相同
+//	"timeout": "$timeout"                  整个值为变量时读取变量的值
+//
+// 只有NAME是合法的环境变量名(字母、数字和_，不以数字开头)的${NAME}和${NAME:-default}被替换，
+// 其他的${...}(如shell的${HOME%/})以及不以{{$开头的{{...}}保持原样。
+// 环境变量不存在且没有默认值时替换为空字符串，$${表示字面量${。环境变量的值不会再进行宏替换。
+// 对象和数组中的字符串同样被替换，返回的是替换后的副本
+const (
+	envRefBegin   = "${"
+	envRefEnd     = "}"
+	envRefEscape  = "$${"
+	envDefaultSep = ":-"
+)
+
+// 读取键值，key可以是顶层的键，也可以是以.分隔的路径
+func (m *Configuration) LookupNameValue(key string, context Context) (interface{}, error) {
+	ret, ok := lookupNameValuePath(m.nameValues, key)
+	if !ok {
+		return nil, fmt.Errorf("name value %s not found", key)
+	}
+	if context == nil {
+		context = &DefaultContext{}
+	}
+	if retStr, ok := ret.(string); ok && len(retStr) > 1 && retStr[0] == '$' && retStr[1] != '{' && retStr[1] != '$' {
+		if retValue, err := m.dict.GetVarValue(retStr, context); err == nil {
+			return retValue, nil
+		}
+		return ret, nil
+	}
+	return m.expandNameValue(ret, context)
+}
+
+// 先按完整的键查找，再按路径逐级查找，路径中的一段可以是包含.的键
+func lookupNameValuePath(values map[string]interface{}, path string) (interface{}, bool) {
+	if ret, ok := values[path]; ok {
+		return ret, true
+	}
+	parts := strings.Split(path, ".")
+	var lookup func(v interface{}, parts []string) (interface{}, bool)
+	lookup = func(v interface{}, parts []string) (interface{}, bool) {
+		if len(parts) == 0 {
+			return v, true
+		}
+		switch tv := v.(type) {
+		case map[string]interface{}:
+			for i := len(parts); i > 0; i-- {
+				if child, ok := tv[strings.Join(parts[:i], ".")]; ok {
+					if ret, ok := lookup(child, parts[i:]); ok {
+						return ret, true
+					}
+				}
+			}
+		case []interface{}:
+			if i, err := strconv.Atoi(parts[0]); err == nil && i >= 0 && i < len(tv) {
+				return lookup(tv[i], parts[1:])
+			}
+		}
+		return nil, false
+	}
+	return lookup(values, parts)
+}
+
+// 替换字符串(包括对象和数组中的字符串)中的环境变量和宏
+func (m *Configuration) expandNameValue(v interface{}, context Context) (interface{}, error) {
+	switch tv := v.(type) {
+	case string:
+		return m.expandNameValueString(tv, context)
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(tv))
+		for k, item := range tv {
+			expanded, err := m.expandNameValue(item, context)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = expanded
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(tv))
+		for i, item := range tv {
+			expanded, err := m.expandNameValue(item, context)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = expanded
+		}
+		return ret, nil
+	}
+	return v, nil
+}
+
+// 环境变量引用或者普通文本
+type envRefPart struct {
+	text       string
+	isRef      bool
+	name       string
+	hasDefault bool
+	dft        string
+}
+
+// 解析字符串中的环境变量引用，不是合法引用的${按普通文本处理
+func parseEnvRefs(s string) []*envRefPart {
+	var ret []*envRefPart
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			ret = append(ret, &envRefPart{text: text.String()})
+			text.Reset()
+		}
+	}
+	for len(s) > 0 {
+		if strings.HasPrefix(s, envRefEscape) {
+			text.WriteString(envRefBegin)
+			s = s[len(envRefEscape):]
+			continue
+		}
+		if !strings.HasPrefix(s, envRefBegin) {
+			text.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		end := strings.Index(s, envRefEnd)
+		if end < 0 {
+			text.WriteString(s)
+			break
+		}
+		part := &envRefPart{isRef: true, name: s[len(envRefBegin):end]}
+		if i := strings.Index(part.name, envDefaultSep); i >= 0 {
+			part.hasDefault, part.dft, part.name = true, part.name[i+len(envDefaultSep):], part.name[:i]
+		}
+		if !isEnvName(part.name) {
+			text.WriteString(envRefBegin)
+			s = s[len(envRefBegin):]
+			continue
+		}
+		flush()
+		ret = append(ret, part)
+		s = s[end+len(envRefEnd):]
+	}
+	flush()
+	return ret
+}
+
+func isEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Configuration) expandNameValueString(s string, context Context) (string, error) {
+	if !strings.Contains(s, envRefBegin) {
+		return m.dict.replaceMacro(s, true, context)
+	}
+	parts := parseEnvRefs(s)
+	var sb strings.Builder
+	for _, part := range parts {
+		if !part.isRef {
+			expanded, err := m.dict.replaceMacro(part.text, true, context)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(expanded)
+			continue
+		}
+		value := ""
+		if env, ok := m.dict.getObject(ObjectEnv); ok {
+			value, _ = GetStringValue(env.GetPropertyValue(part.name, context))
+		}
+		if value == "" && part.hasDefault {
+			value = part.dft
+		}
+		sb.WriteString(value)
+	}
+	return sb.String(), nil
+}
+
+// 加载时检查键值中宏的语法
+func (m *Configuration) checkNameValues() error {
+	var check func(path string, v interface{}) error
+	check = func(path string, v interface{}) error {
+		switch tv := v.(type) {
+		case string:
+			for _, part := range parseEnvRefs(tv) {
+				if !part.isRef && hasMacro(part.text) {
+					if _, err := m.dict.getMacroTemplate(part.text, true); err != nil {
+						return fmt.Errorf("name value %s, %s", path, err.Error())
+					}
+				}
+			}
+		case map[string]interface{}:
+			for k, item := range tv {
+				if err := check(path+"."+k, item); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for i, item := range tv {
+				if err := check(path+"."+strconv.Itoa(i), item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for k, v := range m.nameValues {
+		if err := check(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 配置的一层，Name用于错误信息，如文件名
+type ConfigLayer struct {
+	Name   string
+	Source []byte
+	Format ConfigFormat
+}
+
+// 将多层配置合并为一个配置，后面的层优先: 对象按键递归合并，其他值(包括表达式组)整体替换，
+// 值为null时删除前面的层中的键。vars和buckets同样按变量名、令牌桶名合并。
+// 典型的用法是基础配置 + 环境配置 + 本地覆盖
+func NewLayeredConfiguration(dict *Dictionary, layers ...ConfigLayer) (*Configuration, error) {
+	if len(layers) == 0 || dict == nil {
+		return nil, fmt.Errorf("invalid layers or dict")
+	}
+	var merged map[string]interface{}
+	for i, layer := range layers {
+		var mp map[string]interface{}
+		var err error
+		switch layer.Format {
+		case FormatJSON, FormatJSONC:
+			mp, err = unmarshalJSONC(layer.Source)
+		case FormatYAML:
+			mp, err = unmarshalYAML(layer.Source)
+		default:
+			err = fmt.Errorf("invalid format")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", layer.Name, err.Error())
+		}
+		if i == 0 {
+			merged = mp
+		} else {
+			mergeConfigMap(merged, mp)
+		}
+	}
+	source, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return newConfigurationFromMap(source, merged, dict)
+}
+
+func mergeConfigMap(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeConfigMap(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+// 按顺序加载多个配置文件并合并，后面的文件优先。第一个文件必须存在，之后不存在的文件被忽略(如本地覆盖文件)
+func LoadLayeredConfigurationFiles(dict *Dictionary, fileNames ...string) (*Configuration, error) {
+	var layers []ConfigLayer
+	for i, fileName := range fileNames {
+		source, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		layers = append(layers, ConfigLayer{Name: fileName, Source: source, Format: ConfigFormatOfFile(fileName)})
+	}
+	return NewLayeredConfiguration(dict, layers...)
+}
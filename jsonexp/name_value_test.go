@@ -0,0 +1,165 @@
+package jsonexp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestNameValuePath(t *testing.T) {
+	os.Setenv("JSONEXP_TEST_DB_HOST", "10.0.0.1")
+	defer os.Unsetenv("JSONEXP_TEST_DB_HOST")
+	dict := NewDictionary()
+	dict.RegisterVar("$region", nil)
+	dict.SetEnvAllowlist([]string{"JSONEXP_TEST_*"})
+	cfg, err := NewConfiguration([]byte(`{
+		"db": {
+			"master": {"host": "${JSONEXP_TEST_DB_HOST}", "port": 3306},
+			"slaves": [{"host": "${JSONEXP_TEST_DB_SLAVE:-127.0.0.1}"}]
+		},
+		"a.b": {"c": 1},
+		"url": "http://{{$region}}.example.com/$${PATH}",
+		"api": {"endpoint": "https://{{$region}}.api.com"},
+		"g": [[["$region", "=", "cn"]]]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$region", "cn")
+	cases := map[string]interface{}{
+		"db.master.host":   "10.0.0.1",
+		"db.master.port":   float64(3306),
+		"db.slaves.0.host": "127.0.0.1",
+		"a.b.c":            float64(1),
+		"url":              "http://cn.example.com/${PATH}",
+	}
+	for k, expect := range cases {
+		if v, ok := cfg.GetNameValue(k, ctx); !ok || v != expect {
+			t.Fatalf("%s = %#v, expect %#v", k, v, expect)
+		}
+	}
+	if v, ok := cfg.GetNameValue("api", ctx); !ok || v.(map[string]interface{})["endpoint"] != "https://cn.api.com" {
+		t.Fatalf("api = %#v", v)
+	}
+	if v, _ := cfg.NameValues()["api"].(map[string]interface{})["endpoint"]; v != "https://{{$region}}.api.com" {
+		t.Fatalf("raw name value should not be modified, %v", v)
+	}
+	for _, k := range []string{"db.master.user", "db.slaves.1.host", "g"} {
+		if _, ok := cfg.GetNameValue(k, ctx); ok {
+			t.Fatalf("%s should not be found", k)
+		}
+	}
+
+	dict.SetEnvAllowlist([]string{"APP_*"})
+	if v, _ := cfg.GetNameValue("db.master.host", ctx); v != "" {
+		t.Fatalf("environment variable not in allowlist should be empty, %v", v)
+	}
+
+	if _, err := NewConfiguration([]byte(`{"url": "{{$region|nofunc}}"}`), dict); err == nil {
+		t.Fatalf("expect error for invalid macro")
+	}
+
+	// 不是合法的环境变量引用和宏的文本保持原样
+	cfg, err = NewConfiguration([]byte(`{
+		"greeting": "Hello {{ name }}",
+		"cmd": "echo ${HOME%/} ${1} ${JSONEXP_TEST_DB_HOST",
+		"mixed": "${JSONEXP_TEST_UNSET:-a}-${x y}-{{$region}}"
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cases = map[string]interface{}{
+		"greeting": "Hello {{ name }}",
+		"cmd":      "echo ${HOME%/} ${1} ${JSONEXP_TEST_DB_HOST",
+		"mixed":    "a-${x y}-cn",
+	}
+	for k, expect := range cases {
+		if v, ok := cfg.GetNameValue(k, ctx); !ok || v != expect {
+			t.Fatalf("%s = %#v, expect %#v", k, v, expect)
+		}
+	}
+
+	// 替换失败时返回原始值
+	dict.RegisterPipeFunction("fail", func(v interface{}, context Context) (interface{}, error) {
+		return nil, fmt.Errorf("fail")
+	})
+	cfg, err = NewConfiguration([]byte(`{"tag": "{{$region|fail}}"}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := cfg.LookupNameValue("tag", ctx); err == nil {
+		t.Fatalf("expect error from the pipe function")
+	}
+	if v, ok := cfg.GetNameValue("tag", ctx); !ok || v != "{{$region|fail}}" {
+		t.Fatalf("expect the raw value, got %#v", v)
+	}
+}
+
+func TestLayeredConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonexp")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "base.json")
+	prod := filepath.Join(dir, "prod.yaml")
+	ioutil.WriteFile(base, []byte(`{
+		"vars": {"$level": {"type": "int", "default": 1}},
+		"db": {"host": "localhost", "port": 3306, "debug": true},
+		"timeout": 30,
+		"g": [[["$level", "=", 1], ["$price", "=", 10]]]
+	}`), 0644)
+	ioutil.WriteFile(prod, []byte(`
+vars:
+  $level: {default: 2}
+db:
+  host: db.prod
+  debug: null
+g:
+  - [["$level", "=", 2], ["$price", "=", 20]]
+`), 0644)
+	dict := NewDictionary()
+	dict.RegisterVar("$price", nil)
+	cfg, err := LoadLayeredConfigurationFiles(dict, base, prod, filepath.Join(dir, "local.json"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expect := map[string]interface{}{"db.host": "db.prod", "db.port": float64(3306), "timeout": float64(30)}
+	for k, v := range expect {
+		if got, _ := cfg.GetNameValue(k, nil); got != v {
+			t.Fatalf("%s = %#v, expect %#v", k, got, v)
+		}
+	}
+	if _, ok := cfg.GetNameValue("db.debug", nil); ok {
+		t.Fatalf("db.debug should be removed by null")
+	}
+	if decl, _ := cfg.GetVarDecl("$level"); decl.Type != VarInt || decl.Default != int64(2) {
+		t.Fatalf("unexpected declaration of $level %#v", decl)
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$price"); v != float64(20) {
+		t.Fatalf("$price = %v", v)
+	}
+
+	local := filepath.Join(dir, "local.json")
+	ioutil.WriteFile(local, []byte(`{"timeout": 5}`), 0644)
+	cfg, err = LoadLayeredConfigurationFiles(NewDictionary(), base, prod, local)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := cfg.GetNameValue("timeout", nil); v != float64(5) {
+		t.Fatalf("timeout = %v", v)
+	}
+	if _, err := LoadLayeredConfigurationFiles(NewDictionary(), filepath.Join(dir, "missing.json"), local); err == nil {
+		t.Fatalf("expect error for missing base configuration")
+	}
+}
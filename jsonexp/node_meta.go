@@ -0,0 +1,267 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 节点除了数组形式，还可以写成对象形式:
+//
+//	{
+//		"id": "vip-discount",
+//		"description": "VIP八折",
+//		"owner": "alice",
+//		"enabled": true,
+//		"start": "2024-01-01 00:00:00",
+//		"end": "2024-02-01 00:00:00",
+//		"weight": 50,
+//		"when": [["$is_vip", "=", true]],
+//		"then": ["$discount", "=", 0.8]
+//	}
+//
+// when为比较表达式的数组，可以省略; then为一个赋值表达式或者赋值表达式的数组，与数组形式的最后一项相同。
+// enabled为false的节点、当前时间(Dictionary.Now)不在[start, end)内的节点被跳过，start和end为绝对时间;
+// weight为0-100，节点以weight%的概率执行，默认为100。
+// id在表达式组内唯一，出现在执行跟踪、节点统计、赋值事件和错误信息中
+const (
+	nodeFieldID          = "id"
+	nodeFieldDescription = "description"
+	nodeFieldOwner       = "owner"
+	nodeFieldEnabled     = "enabled"
+	nodeFieldStart       = "start"
+	nodeFieldEnd         = "end"
+	nodeFieldWeight      = "weight"
+	nodeFieldWhen        = "when"
+	nodeFieldThen        = "then"
+)
+
+// 节点被跳过的原因
+const (
+	NodeSkipDisabled   = "disabled"
+	NodeSkipNotStarted = "not started"
+	NodeSkipExpired    = "expired"
+	NodeSkipWeight     = "weight"
+)
+
+// 节点的属性，数组形式的节点Enabled为true，Weight为100
+type NodeMeta struct {
+	ID          string    `json:"id,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	Start       time.Time `json:"start"` // 零值表示不限制
+	End         time.Time `json:"end"`
+	Weight      int       `json:"weight"`
+}
+
+func defaultNodeMeta() NodeMeta {
+	return NodeMeta{Enabled: true, Weight: 100}
+}
+
+// 节点是否有属性以外的执行条件
+func (m *NodeMeta) conditional() bool {
+	return !m.Enabled || !m.Start.IsZero() || !m.End.IsZero() || m.Weight < 100
+}
+
+// 节点在now时被跳过的原因，不跳过时返回空字符串
+func (m *NodeMeta) skipReason(now time.Time) string {
+	switch {
+	case !m.Enabled:
+		return NodeSkipDisabled
+	case !m.Start.IsZero() && now.Before(m.Start):
+		return NodeSkipNotStarted
+	case !m.End.IsZero() && !now.Before(m.End):
+		return NodeSkipExpired
+	case m.Weight < 100 && rand.Intn(100) >= m.Weight:
+		return NodeSkipWeight
+	}
+	return ""
+}
+
+// 解析对象形式的节点，返回节点的属性和等价的数组形式
+func parseNodeObject(source map[string]interface{}, now time.Time) (NodeMeta, []interface{}, error) {
+	meta := defaultNodeMeta()
+	var node []interface{}
+	hasThen := false
+	for k, v := range source {
+		var ok bool
+		switch k {
+		case nodeFieldID, nodeFieldDescription, nodeFieldOwner:
+			var s string
+			if s, ok = v.(string); ok {
+				switch k {
+				case nodeFieldID:
+					meta.ID = s
+				case nodeFieldDescription:
+					meta.Description = s
+				default:
+					meta.Owner = s
+				}
+			}
+		case nodeFieldEnabled:
+			meta.Enabled, ok = v.(bool)
+		case nodeFieldStart, nodeFieldEnd:
+			var t time.Time
+			if s, isStr := v.(string); isStr {
+				if _, relative := parseRelativeDateTime(strings.TrimSpace(s), now); relative {
+					return meta, nil, fmt.Errorf("%s of node must be an absolute time", k)
+				}
+			}
+			if t, ok = GetDateTimeValue(v, now); ok {
+				if k == nodeFieldStart {
+					meta.Start = t
+				} else {
+					meta.End = t
+				}
+			}
+		case nodeFieldWeight:
+			var f float64
+			if f, ok = GetFloatValue(v); ok && GetValueType(v) != VarStr && f >= 0 && f <= 100 && f == float64(int(f)) {
+				meta.Weight = int(f)
+			} else {
+				ok = false
+			}
+		case nodeFieldWhen:
+			var list []interface{}
+			if list, ok = v.([]interface{}); ok {
+				node = append(list[:len(list):len(list)], node...)
+			}
+		case nodeFieldThen:
+			hasThen = true
+			var list []interface{}
+			if list, ok = v.([]interface{}); ok {
+				node = append(node, list)
+			}
+		default:
+			return meta, nil, fmt.Errorf("unknown node field %s", k)
+		}
+		if !ok {
+			return meta, nil, fmt.Errorf("invalid node field %s: %v", k, v)
+		}
+	}
+	if !hasThen {
+		return meta, nil, fmt.Errorf("node field %s is required", nodeFieldThen)
+	}
+	if !meta.Start.IsZero() && !meta.End.IsZero() && !meta.Start.Before(meta.End) {
+		return meta, nil, fmt.Errorf("start of node must be before end")
+	}
+	return meta, node, nil
+}
+
+// v是否包含对象形式的节点(有when或then的对象)，用于区分解析失败的表达式组和普通的键值
+func hasNodeObject(v interface{}) bool {
+	list, ok := v.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range list {
+		if obj, ok := item.(map[string]interface{}); ok {
+			_, hasWhen := obj[nodeFieldWhen]
+			_, hasThen := obj[nodeFieldThen]
+			if hasWhen || hasThen {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 节点的属性
+func (m *JsonExp) Meta() NodeMeta {
+	return m.meta
+}
+
+// 节点的id，没有设置时为空字符串
+func (m *JsonExp) ID() string {
+	return m.meta.ID
+}
+
+// 节点在错误信息中的标识: 序号，设置了id时为序号(id)
+func (m *JsonExp) label() string {
+	if m.meta.ID == "" {
+		return strconv.Itoa(m.index)
+	}
+	return fmt.Sprintf("%d(%s)", m.index, m.meta.ID)
+}
+
+// 设置了id的节点执行出错时返回的错误，可以通过errors.Is/errors.As获取原始错误
+type NodeError struct {
+	Group     string
+	NodeIndex int
+	NodeID    string
+	Err       error
+}
+
+func (m *NodeError) Error() string {
+	return fmt.Sprintf("group %s, node %d(%s): %s", m.Group, m.NodeIndex, m.NodeID, m.Err.Error())
+}
+
+func (m *NodeError) Unwrap() error {
+	return m.Err
+}
+
+func (m *JsonExp) wrapError(err error) error {
+	return wrapNodeError(err, m.groupName(), m.index, m.meta.ID)
+}
+
+// 有id的节点的错误包装为*NodeError
+func wrapNodeError(err error, groupName string, nodeIndex int, nodeID string) error {
+	if err == nil || nodeID == "" {
+		return err
+	}
+	return &NodeError{Group: groupName, NodeIndex: nodeIndex, NodeID: nodeID, Err: err}
+}
+
+// 节点的执行统计
+type NodeStat struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Executed int64  `json:"executed"` // 没有被跳过的次数
+	Matched  int64  `json:"matched"`
+	Skipped  int64  `json:"skipped"`
+	Errors   int64  `json:"errors"`
+}
+
+type nodeCounters struct {
+	executed int64
+	matched  int64
+	skipped  int64
+	errors   int64
+}
+
+// 表达式组中每个节点的执行统计
+func (m *JsonExpGroup) NodeStats() []*NodeStat {
+	ret := make([]*NodeStat, len(m.group))
+	for i, exp := range m.group {
+		ret[i] = &NodeStat{
+			Index:    i,
+			ID:       exp.meta.ID,
+			Executed: atomic.LoadInt64(&exp.counters.executed),
+			Matched:  atomic.LoadInt64(&exp.counters.matched),
+			Skipped:  atomic.LoadInt64(&exp.counters.skipped),
+			Errors:   atomic.LoadInt64(&exp.counters.errors),
+		}
+	}
+	return ret
+}
+
+// 按id查找节点
+func (m *JsonExpGroup) GetNode(id string) (*JsonExp, bool) {
+	if id == "" {
+		return nil, false
+	}
+	for _, exp := range m.group {
+		if exp.meta.ID == id {
+			return exp, true
+		}
+	}
+	return nil, false
+}
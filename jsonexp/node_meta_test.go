@@ -0,0 +1,129 @@
+package jsonexp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestNodeMeta(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local)
+	dict := NewDictionary()
+	dict.SetClock(func() time.Time { return now })
+	dict.RegisterVar("$price", nil)
+	dict.RegisterVar("$tag", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$price", "=", 1]],
+			{"id": "off", "enabled": false, "then": ["$price", "=", 2]},
+			{"id": "future", "start": "2024-02-01", "then": ["$price", "=", 3]},
+			{"id": "past", "end": "2024-01-01", "then": ["$price", "=", 4]},
+			{"id": "never", "weight": 0, "then": ["$price", "=", 5]},
+			{
+				"id": "vip",
+				"description": "VIP价格",
+				"owner": "alice",
+				"start": "2024-01-01",
+				"end": "2024-02-01",
+				"when": [["$tag", "=", "vip"]],
+				"then": [["$price", "*=", 10], ["$tag", "=", "done"]]
+			}
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	if vip, ok := g.GetNode("vip"); !ok || vip.Meta().Owner != "alice" || len(vip.GetCompareExpList()) != 1 || len(vip.GetAssignExpList()) != 2 {
+		t.Fatalf("unexpected node vip %#v", vip)
+	}
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$tag", "vip")
+	trace := EnableTrace(ctx)
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$price"); v != float64(10) {
+		t.Fatalf("$price = %v", v)
+	}
+	s := trace.String()
+	for _, expect := range []string{"node 1 (off): skipped=disabled", "node 2 (future): skipped=not started", "node 3 (past): skipped=expired", "node 4 (never): skipped=weight", "node 5 (vip): matched=true"} {
+		if !strings.Contains(s, expect) {
+			t.Fatalf("trace should contain %s:\n%s", expect, s)
+		}
+	}
+	stats := g.NodeStats()
+	if stats[0].Executed != 1 || stats[1].Skipped != 1 || stats[5].ID != "vip" || stats[5].Matched != 1 {
+		t.Fatalf("unexpected node stats %#v %#v %#v", stats[0], stats[1], stats[5])
+	}
+
+	if _, err := GenerateGo(cfg, GoGenOptions{}); err == nil {
+		t.Fatalf("expect error generating code for nodes with time window")
+	}
+
+	now = now.AddDate(0, 1, 0)
+	ctx = &goutil.DefaultContext{}
+	ctx.SetCtxData("$tag", "vip")
+	g.Execute(ctx)
+	if v, _ := ctx.GetCtxData("$price"); v != float64(3) {
+		t.Fatalf("$price = %v", v)
+	}
+
+	invalid := []string{
+		`{"g": [{"id": "a", "then": ["$price", "=", 1]}, {"id": "a", "then": ["$price", "=", 2]}]}`,
+		`{"g": [{"id": "a", "weight": 101, "then": ["$price", "=", 1]}]}`,
+		`{"g": [{"id": "a", "start": "now-1d", "then": ["$price", "=", 1]}]}`,
+		`{"g": [{"id": "a", "start": "2024-02-01", "end": "2024-01-01", "then": ["$price", "=", 1]}]}`,
+		`{"g": [{"id": "a", "when": [["$price", "=", 1]]}]}`,
+		`{"g": [{"id": "a", "enable": true, "then": ["$price", "=", 1]}]}`,
+		`{"vars": {"$n": "int"}, "g": [{"id": "bad", "then": ["$n", "=", "x"]}]}`,
+	}
+	for _, source := range invalid {
+		if _, err := NewConfiguration([]byte(source), dict); err == nil {
+			t.Fatalf("expect error for %s", source)
+		} else if strings.Contains(source, `"bad"`) && !strings.Contains(err.Error(), "node 0(bad)") {
+			t.Fatalf("error should contain node id, %s", err.Error())
+		}
+	}
+}
+
+func TestNodeError(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$n", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			{"id": "init", "then": ["$n", "=", 1]},
+			{"id": "div", "then": ["$n", "/=", 0]}
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	var events []*AssignEvent
+	ctx := &goutil.DefaultContext{}
+	AddContextAssignObserver(ctx, func(event *AssignEvent, context Context) {
+		events = append(events, event)
+	})
+	err = g.Execute(ctx)
+	var nodeErr *NodeError
+	if !errors.As(err, &nodeErr) || nodeErr.NodeID != "div" || nodeErr.NodeIndex != 1 || nodeErr.Group != "g" {
+		t.Fatalf("expect NodeError, got %v", err)
+	}
+	if g.NodeStats()[1].Errors != 1 {
+		t.Fatalf("expect error counted")
+	}
+	if len(events) != 1 || events[0].NodeID != "init" || eventLocation(events[0]) != "g#init" {
+		t.Fatalf("unexpected assign events %#v", events)
+	}
+
+	oldCfg, _ := NewConfiguration([]byte(`{"g": [{"id": "a", "then": ["$n", "=", 1]}]}`), dict)
+	newCfg, _ := NewConfiguration([]byte(`{"g": [{"id": "a", "enabled": false, "when": [["$n", "=", 0]], "then": ["$n", "=", 1]}]}`), dict)
+	diff := DiffConfigurations(oldCfg, newCfg).String()
+	if !strings.Contains(diff, "~ node 0 -> 0 (a)") || !strings.Contains(diff, "~ enabled: true -> false") {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 上下文中保存赋值观察者的键
+const ContextKeyAssignObservers = "__JSONEXP_ASSIGN_OBSERVERS__"
+
+// 一次成功的赋值
+type AssignEvent struct {
+	VarName   string      `json:"var"`
+	Operator  string      `json:"op"`
+	OldValue  interface{} `json:"old"`
+	NewValue  interface{} `json:"new"`
+	Group     string      `json:"group,omitempty"`
+	NodeIndex int         `json:"node"` // 不是通过表达式组执行的赋值为-1
+	NodeID    string      `json:"node_id,omitempty"`
+}
+
+// 赋值观察者，每次成功的赋值(包括变量和对象属性)后被调用
+type AssignObserver func(event *AssignEvent, context Context)
+
+// 添加字典级别的赋值观察者，对所有使用该字典的执行生效
+func (m *Dictionary) AddAssignObserver(observer AssignObserver) {
+	if observer == nil {
+		return
+	}
+	m.mustNotFrozen("AddAssignObserver")
+	m.assignObserverListLock.Lock()
+	defer m.assignObserverListLock.Unlock()
+	m.assignObserverList = append(m.assignObserverList, observer)
+	m.changed()
+}
+
+// 添加上下文级别的赋值观察者，只对该上下文中的执行生效
+func AddContextAssignObserver(context Context, observer AssignObserver) {
+	if context == nil || observer == nil {
+		return
+	}
+	var list []AssignObserver
+	if v, ok := context.GetCtxData(ContextKeyAssignObservers); ok {
+		list, _ = v.([]AssignObserver)
+	}
+	context.SetCtxData(ContextKeyAssignObservers, append(list, observer))
+}
+
+func (m *Dictionary) getAssignObservers(context Context) []AssignObserver {
+	unlock := m.rlock(&m.assignObserverListLock)
+	ret := m.assignObserverList
+	unlock()
+	if context != nil {
+		if v, ok := context.GetCtxData(ContextKeyAssignObservers); ok {
+			if list, ok := v.([]AssignObserver); ok && len(list) > 0 {
+				ret = append(ret[:len(ret):len(ret)], list...)
+			}
+		}
+	}
+	return ret
+}
+
+// 获取被赋值对象的当前值，用于通知观察者
+func (m *Dictionary) observedValue(assignName string, left string, context Context) interface{} {
+	if assignName == "=" || assignName == "unset" {
+		if obj, property, ok := m.lookupObjectProperty(left, context); ok {
+			return obj.GetPropertyValue(property, context)
+		}
+	}
+	if context == nil {
+		return nil
+	}
+	ret, _ := context.GetCtxData(left)
+	return ret
+}
+
+// 变更记录中的一项，对同一个变量的多次赋值合并为一项
+type Change struct {
+	VarName   string      `json:"var"`
+	OldValue  interface{} `json:"old"`
+	NewValue  interface{} `json:"new"`
+	Operators []string    `json:"ops"`
+	Locations []string    `json:"locations"` // group#node
+}
+
+// 内置的赋值观察者，收集赋值事件并生成简洁的变更记录
+type ChangeLogCollector struct {
+	sync.Mutex
+	events []*AssignEvent
+}
+
+func NewChangeLogCollector() *ChangeLogCollector {
+	return &ChangeLogCollector{}
+}
+
+// 返回赋值观察者，可以传给Dictionary.AddAssignObserver或者AddContextAssignObserver
+func (m *ChangeLogCollector) Observer() AssignObserver {
+	return func(event *AssignEvent, context Context) {
+		m.Lock()
+		defer m.Unlock()
+		m.events = append(m.events, event)
+	}
+}
+
+// 所有的赋值事件
+func (m *ChangeLogCollector) Events() []*AssignEvent {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]*AssignEvent, len(m.events))
+	copy(ret, m.events)
+	return ret
+}
+
+func (m *ChangeLogCollector) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.events = nil
+}
+
+// 按变量合并后的变更记录，按变量第一次被赋值的顺序排列。最终值与初始值相同的变量不包含在内
+func (m *ChangeLogCollector) Changes() []*Change {
+	m.Lock()
+	defer m.Unlock()
+	var ret []*Change
+	index := make(map[string]*Change)
+	for _, e := range m.events {
+		change, ok := index[e.VarName]
+		if !ok {
+			change = &Change{VarName: e.VarName, OldValue: e.OldValue}
+			index[e.VarName] = change
+			ret = append(ret, change)
+		}
+		change.NewValue = e.NewValue
+		change.Operators = append(change.Operators, e.Operator)
+		change.Locations = append(change.Locations, eventLocation(e))
+	}
+	compact := ret[:0]
+	for _, v := range ret {
+		if fmt.Sprintf("%#v", v.OldValue) != fmt.Sprintf("%#v", v.NewValue) {
+			compact = append(compact, v)
+		}
+	}
+	return compact
+}
+
+func eventLocation(e *AssignEvent) string {
+	if e.NodeIndex < 0 {
+		return e.Group
+	}
+	if e.NodeID != "" {
+		return fmt.Sprintf("%s#%s", e.Group, e.NodeID)
+	}
+	return fmt.Sprintf("%s#%d", e.Group, e.NodeIndex)
+}
+
+// 变更记录文本，每行一个变量: $var: old -> new [op@group#node, ...]
+func (m *ChangeLogCollector) String() string {
+	var sb strings.Builder
+	for _, c := range m.Changes() {
+		fmt.Fprintf(&sb, "%s: %v -> %v [", c.VarName, c.OldValue, c.NewValue)
+		for i, op := range c.Operators {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(op)
+			if loc := c.Locations[i]; loc != "" {
+				sb.WriteString("@" + loc)
+			}
+		}
+		sb.WriteString("]\n")
+	}
+	return sb.String()
+}
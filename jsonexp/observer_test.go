@@ -0,0 +1,64 @@
+package jsonexp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestAssignObserver(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$n", nil)
+	myobj := &MyObj{ver: "v1"}
+	dict.RegisterObject("$resp", myobj)
+	var dictEvents []*AssignEvent
+	dict.AddAssignObserver(func(event *AssignEvent, context Context) {
+		dictEvents = append(dictEvents, event)
+	})
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$n", "=", 1]],
+			[[["$n", "=", 5], ["$resp.source_icon", "=", "v2"]]],
+			[["$n", "+=", 1]],
+			[["$n", "-=", 1]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	collector := NewChangeLogCollector()
+	AddContextAssignObserver(ctx, collector.Observer())
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	events := collector.Events()
+	if len(events) != 5 || len(dictEvents) != 5 {
+		t.Fatalf("expect 5 events, got %d, %d", len(events), len(dictEvents))
+	}
+	e := events[2]
+	if e.VarName != "$resp.source_icon" || e.OldValue != "v1" || e.NewValue != "v2" || e.Group != "g" || e.NodeIndex != 1 {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if e := events[3]; e.Operator != "+=" || e.OldValue.(float64) != 5 || e.NewValue.(float64) != 6 {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	changes := collector.Changes()
+	if len(changes) != 2 || changes[0].VarName != "$n" || len(changes[0].Operators) != 4 {
+		t.Fatalf("unexpected changes: %s", collector.String())
+	}
+	if !strings.Contains(collector.String(), "$resp.source_icon: v1 -> v2 [=@g#1]") {
+		t.Fatalf("unexpected change log: %s", collector.String())
+	}
+
+	// 赋值失败时不通知
+	collector.Reset()
+	dict.Assign("/=", "$n", 0, ctx)
+	if len(collector.Events()) != 0 {
+		t.Fatalf("failed assignment should not be observed")
+	}
+}
@@ -22,7 +22,12 @@ const (
 	PipelineFnMd5Upper = "MD5"
 )
 
+// 字符串返回字符个数，列表返回元素个数
 func pipeFnLen(input interface{}, context Context) (interface{}, error) {
+	if GetValueType(input) == VarSlice {
+		list, _ := GetListValue(input)
+		return len(list), nil
+	}
 	if s, ok := GetStringValue(input); ok {
 		return len(s), nil
 	} else {
@@ -88,6 +93,9 @@ func pipeFnFnvMd5Upper(input interface{}, context Context) (interface{}, error)
 
 type PipeFunction func(input interface{}, context Context) (output interface{}, err error)
 
+// 带参数的管道函数，格式为 $varName|functionName:arg
+type PipeArgFunction func(input interface{}, arg string, context Context) (output interface{}, err error)
+
 type pipeline struct {
 	OriginName   string
 	FunctionList []PipeFunction
@@ -107,7 +115,7 @@ func newPipeline(varName string, dict *Dictionary) (*pipeline, error) {
 		if i == 0 {
 			ret.OriginName = v
 		} else {
-			fn := dict.GetPipeFunction(v)
+			fn := dict.resolvePipeFunction(v)
 			if fn != nil {
 				ret.FunctionList = append(ret.FunctionList, fn)
 			} else {
@@ -0,0 +1,343 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+// Configuration中的保留键，用于声明限流令牌桶:
+//
+//	"buckets": {
+//		"enrich": {"capacity": 500, "qps": 500},
+//		"per_user": {"capacity": 100, "qps": 100, "per_key": true, "max_keys": 10000}
+//	}
+//
+// 比较运算符ratelimit从令牌桶中获取令牌，获取成功时条件成立:
+//
+//	["$_", "ratelimit", "enrich"]         整个配置共用一个令牌桶，左值被忽略
+//	["$user_id", "ratelimit", "per_user"] per_key为true时，每个左值一个令牌桶
+//
+// 比较运算按顺序短路执行，ratelimit应放在节点的最后一个条件，避免在其他条件不成立时消耗令牌。
+// 共用的令牌桶基于goutil.TokenBucket，qps不能小于100。per_key的令牌桶按同样的速率和容量计算，
+// 但不启动goroutine，在获取令牌时根据距上次获取的时间补充令牌
+const (
+	ConfigurationBucketsKey = "buckets"
+	CompareRateLimit        = "ratelimit"
+)
+
+// 上下文中保存当前执行的配置的令牌桶的键
+const ContextKeyRateLimiters = "__JSONEXP_RATE_LIMITERS__"
+
+const defaultBucketMaxKeys = 10000
+
+// 令牌桶声明
+type BucketDecl struct {
+	Name     string `json:"name"`
+	Capacity int64  `json:"capacity"`
+	Qps      int64  `json:"qps"`
+	PerKey   bool   `json:"per_key"`
+	MaxKeys  int    `json:"max_keys"` // per_key为true时最多保存的令牌桶数量，超出时丢弃最久未使用的令牌桶
+}
+
+// 令牌桶的统计
+type BucketStat struct {
+	BucketDecl
+	Keys     int   `json:"keys"` // 当前的令牌桶数量
+	Allowed  int64 `json:"allowed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// per_key的令牌桶，令牌数在获取时按时间补充，与goutil.TokenBucket一样每100ms补充qps/10+1个
+type keyedBucket struct {
+	key      string
+	tokens   float64
+	capacity float64
+	rate     float64 // 每纳秒补充的令牌数
+	last     time.Time
+	elem     *list.Element
+}
+
+func newKeyedBucket(key string, capacity int64, qps int64, now time.Time) *keyedBucket {
+	rate := qps/10 + 1
+	if capacity < rate {
+		capacity = rate
+	}
+	return &keyedBucket{
+		key:      key,
+		tokens:   float64(rate),
+		capacity: float64(capacity),
+		rate:     float64(rate) / float64(100*time.Millisecond),
+		last:     now,
+	}
+}
+
+func (m *keyedBucket) returnToken() {
+	if m.tokens++; m.tokens > m.capacity {
+		m.tokens = m.capacity
+	}
+}
+
+func (m *keyedBucket) getToken(now time.Time) bool {
+	if elapsed := now.Sub(m.last); elapsed > 0 {
+		m.tokens += float64(elapsed) * m.rate
+		if m.tokens > m.capacity {
+			m.tokens = m.capacity
+		}
+		m.last = now
+	}
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+type rateLimiter struct {
+	// 原子操作的int64放在最前面，保证在32位平台上8字节对齐
+	allowed  int64
+	rejected int64
+	decl     BucketDecl
+	lock     sync.Mutex
+	shared   *goutil.TokenBucket
+	keyed    map[string]*keyedBucket
+	lru      *list.List
+}
+
+// 获取令牌，调用方持有m.lock
+func (m *rateLimiter) getToken(key string, now time.Time) bool {
+	if !m.decl.PerKey {
+		if m.shared == nil {
+			m.shared = goutil.NewTokenBucket(m.decl.Capacity, m.decl.Qps)
+		}
+		return m.shared.GetToken()
+	}
+	if kb, ok := m.keyed[key]; ok {
+		m.lru.MoveToFront(kb.elem)
+		return kb.getToken(now)
+	}
+	kb := newKeyedBucket(key, m.decl.Capacity, m.decl.Qps, now)
+	kb.elem = m.lru.PushFront(kb)
+	m.keyed[key] = kb
+	for len(m.keyed) > m.decl.MaxKeys {
+		oldest := m.lru.Back().Value.(*keyedBucket)
+		m.lru.Remove(oldest.elem)
+		delete(m.keyed, oldest.key)
+	}
+	return kb.getToken(now)
+}
+
+// 归还getToken获取的令牌，调用方持有m.lock。令牌桶已经停止或者被淘汰时忽略
+func (m *rateLimiter) returnToken(key string) {
+	if !m.decl.PerKey {
+		if m.shared != nil {
+			m.shared.ReturnToken()
+		}
+		return
+	}
+	if kb, ok := m.keyed[key]; ok {
+		kb.returnToken()
+	}
+}
+
+func (m *rateLimiter) stop() {
+	if m.shared != nil {
+		m.shared.Stop()
+		m.shared = nil
+	}
+	m.keyed = make(map[string]*keyedBucket)
+	m.lru = list.New()
+}
+
+// 一个配置中声明的所有令牌桶，令牌桶在第一次使用时创建，配置关闭时停止
+type rateLimiterSet struct {
+	closed   int32
+	limiters map[string]*rateLimiter
+}
+
+func parseBucketDecls(source interface{}) (map[string]*rateLimiter, error) {
+	mp, ok := source.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not an object", ConfigurationBucketsKey)
+	}
+	ret := make(map[string]*rateLimiter)
+	for name, v := range mp {
+		src, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid declaration of bucket %s", name)
+		}
+		decl := BucketDecl{Name: name, MaxKeys: defaultBucketMaxKeys}
+		if decl.Qps, ok = GetIntValue(src["qps"]); !ok || decl.Qps < 100 {
+			return nil, fmt.Errorf("qps of bucket %s must be a number >= 100", name)
+		}
+		decl.Capacity = decl.Qps
+		if c, exists := src["capacity"]; exists {
+			if decl.Capacity, ok = GetIntValue(c); !ok || decl.Capacity <= 0 {
+				return nil, fmt.Errorf("invalid capacity of bucket %s", name)
+			}
+		}
+		if pk, exists := src["per_key"]; exists {
+			if decl.PerKey, ok = pk.(bool); !ok {
+				return nil, fmt.Errorf("invalid per_key of bucket %s", name)
+			}
+		}
+		if mk, exists := src["max_keys"]; exists {
+			n, ok := GetIntValue(mk)
+			if !ok || n <= 0 {
+				return nil, fmt.Errorf("invalid max_keys of bucket %s", name)
+			}
+			decl.MaxKeys = int(n)
+		}
+		ret[name] = &rateLimiter{decl: decl, keyed: make(map[string]*keyedBucket), lru: list.New()}
+	}
+	return ret, nil
+}
+
+// 获取令牌。在事务上下文中(如配置管理接口的dry run)回滚时归还令牌并撤销统计
+func (m *rateLimiterSet) take(name string, key interface{}, context Context) (bool, error) {
+	limiter, ok := m.limiters[name]
+	if !ok {
+		return false, fmt.Errorf("bucket %s not found", name)
+	}
+	keyStr := ""
+	if limiter.decl.PerKey {
+		var err error
+		if keyStr, err = counterKey(key); err != nil {
+			return false, err
+		}
+	}
+	limiter.lock.Lock()
+	allowed := false
+	if atomic.LoadInt32(&m.closed) == 0 {
+		allowed = limiter.getToken(keyStr, time.Now())
+	}
+	limiter.lock.Unlock()
+	counter := &limiter.rejected
+	if allowed {
+		counter = &limiter.allowed
+	}
+	atomic.AddInt64(counter, 1)
+	if tx, ok := context.(*TxContext); ok {
+		tx.RecordUndo(func() {
+			atomic.AddInt64(counter, -1)
+			if allowed {
+				limiter.lock.Lock()
+				limiter.returnToken(keyStr)
+				limiter.lock.Unlock()
+			}
+		})
+	}
+	return allowed, nil
+}
+
+// 停止所有令牌桶，停止后ratelimit条件不成立，直到reopen
+func (m *rateLimiterSet) close() {
+	if !atomic.CompareAndSwapInt32(&m.closed, 0, 1) {
+		return
+	}
+	for _, limiter := range m.limiters {
+		limiter.lock.Lock()
+		limiter.stop()
+		limiter.lock.Unlock()
+	}
+}
+
+func (m *rateLimiterSet) reopen() {
+	atomic.StoreInt32(&m.closed, 0)
+}
+
+func (m *rateLimiterSet) stats() []*BucketStat {
+	ret := make([]*BucketStat, 0, len(m.limiters))
+	for _, limiter := range m.limiters {
+		limiter.lock.Lock()
+		keys := len(limiter.keyed)
+		if limiter.shared != nil {
+			keys = 1
+		}
+		limiter.lock.Unlock()
+		ret = append(ret, &BucketStat{
+			BucketDecl: limiter.decl,
+			Keys:       keys,
+			Allowed:    atomic.LoadInt64(&limiter.allowed),
+			Rejected:   atomic.LoadInt64(&limiter.rejected),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// 执行期间在上下文中设置表达式组所属配置的令牌桶，返回恢复函数
+func (m *rateLimiterSet) enter(context Context) func() {
+	if m == nil || context == nil {
+		return func() {}
+	}
+	old, hasOld := context.GetCtxData(ContextKeyRateLimiters)
+	context.SetCtxData(ContextKeyRateLimiters, m)
+	return func() {
+		if hasOld {
+			context.SetCtxData(ContextKeyRateLimiters, old)
+		} else {
+			context.RemoveCtxData(ContextKeyRateLimiters)
+		}
+	}
+}
+
+// ratelimit 从右值指定的令牌桶中获取令牌
+var RateLimitCompare = func(leftValue interface{}, rightValue interface{}, context Context) (bool, error) {
+	name, ok := rightValue.(string)
+	if !ok {
+		return false, fmt.Errorf("invalid bucket name %v", rightValue)
+	}
+	if context == nil {
+		return false, fmt.Errorf("param context is nil")
+	}
+	v, _ := context.GetCtxData(ContextKeyRateLimiters)
+	set, ok := v.(*rateLimiterSet)
+	if !ok {
+		return false, fmt.Errorf("no bucket declared")
+	}
+	return set.take(name, leftValue, context)
+}
+
+// 检查表达式组中引用的令牌桶是否已声明
+func (m *JsonExpGroup) checkBuckets(limiters map[string]*rateLimiter) error {
+	for _, exp := range m.group {
+		for _, v := range exp.compareExpList {
+			if v.CompareName != CompareRateLimit {
+				continue
+			}
+			name, ok := v.Right.(string)
+			if !ok {
+				return fmt.Errorf("node %s, invalid bucket name %v", exp.label(), v.Right)
+			}
+			if _, ok := limiters[name]; !ok {
+				return fmt.Errorf("node %s, bucket %s not declared", exp.label(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// 配置中令牌桶的统计
+func (m *Configuration) BucketStats() []*BucketStat {
+	if m.limiters == nil {
+		return nil
+	}
+	return m.limiters.stats()
+}
+
+// 停止配置中声明的所有令牌桶。ConfigStore在配置被替换时自动关闭旧的配置
+func (m *Configuration) Close() {
+	if m.limiters != nil {
+		m.limiters.close()
+	}
+}
@@ -0,0 +1,144 @@
+package jsonexp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestRateLimitCompare(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$user_id", nil)
+	dict.RegisterVar("$enrich", nil)
+	source := `{
+		"buckets": {
+			"enrich": {"capacity": 5, "qps": 100},
+			"per_user": {"qps": 100, "per_key": true, "max_keys": 2}
+		},
+		"g": [
+			[["$_", "ratelimit", "enrich"], ["$enrich", "=", 1]]
+		],
+		"u": [
+			[["$user_id", "ratelimit", "per_user"], ["$enrich", "=", 1]]
+		]
+	}`
+	cfg, err := NewConfiguration([]byte(source), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := cfg.GetNameValue(ConfigurationBucketsKey, nil); ok {
+		t.Fatalf("buckets should not be a name value")
+	}
+	run := func(group string, user string) bool {
+		g, _ := cfg.GetJsonExpGroup(group)
+		ctx := &goutil.DefaultContext{}
+		ctx.SetCtxData("$user_id", user)
+		if err := g.Execute(ctx); err != nil {
+			t.Fatalf(err.Error())
+		}
+		_, ok := ctx.GetCtxData("$enrich")
+		return ok
+	}
+	// qps为100时每100ms生产11个令牌，容量不小于11
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if run("g", "") {
+			allowed++
+		}
+	}
+	if allowed < 11 || allowed >= 30 {
+		t.Fatalf("unexpected allowed count %d", allowed)
+	}
+	for i := 0; i < 30; i++ {
+		run("u", "u1")
+	}
+	if !run("u", "u2") {
+		t.Fatalf("u2 should have its own bucket")
+	}
+	run("u", "u3")
+
+	stats := cfg.BucketStats()
+	if len(stats) != 2 || stats[0].Name != "enrich" || stats[0].Allowed != int64(allowed) || stats[0].Rejected != int64(30-allowed) {
+		t.Fatalf("unexpected stats %+v", stats[0])
+	}
+	if stats[1].Name != "per_user" || stats[1].Keys != 2 || !stats[1].PerKey {
+		t.Fatalf("unexpected stats %+v", stats[1])
+	}
+
+	cfg.Close()
+	if run("u", "u4") {
+		t.Fatalf("closed bucket should reject")
+	}
+	cfg.limiters.reopen()
+	if !run("u", "u5") {
+		t.Fatalf("reopened bucket should allow")
+	}
+	cfg.Close()
+
+	for _, s := range []string{
+		`{"buckets": {"b": {"qps": 10}}, "g": [[["$_", "ratelimit", "b"], ["$x", "=", 1]]]}`,
+		`{"buckets": {"b": {"qps": 100}}, "g": [[["$_", "ratelimit", "c"], ["$x", "=", 1]]]}`,
+		`{"g": [[["$_", "ratelimit", "b"], ["$x", "=", 1]]]}`,
+	} {
+		if _, err := NewConfiguration([]byte(s), dict); err == nil {
+			t.Fatalf("expect error for %s", s)
+		}
+	}
+}
+
+func TestConfigStoreClosesReplacedConfiguration(t *testing.T) {
+	dict := NewDictionary()
+	store := NewConfigStore(dict, 0)
+	source := []byte(`{"buckets": {"b": {"qps": 100}}, "g": [[["$_", "ratelimit", "b"], ["$x", "=", 1]]]}`)
+	v1, err := store.Put("c", source, FormatJSON)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store.Put("c", source, FormatJSON)
+	if v1.config.limiters.closed == 0 {
+		t.Fatalf("replaced configuration should be closed")
+	}
+	store.Rollback("c", 1)
+	if v1.config.limiters.closed != 0 {
+		t.Fatalf("configuration should be reopened after rollback")
+	}
+	store.Delete("c")
+	if v1.config.limiters.closed == 0 {
+		t.Fatalf("deleted configuration should be closed")
+	}
+}
+
+func TestKeyedBucketRefill(t *testing.T) {
+	now := time.Now()
+	// qps为100时每100ms补充11个令牌，容量不小于11
+	b := newKeyedBucket("k", 5, 100, now)
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if b.getToken(now) {
+			allowed++
+		}
+	}
+	if allowed != 11 {
+		t.Fatalf("unexpected initial tokens %d", allowed)
+	}
+	allowed = 0
+	for i := 0; i < 20; i++ {
+		if b.getToken(now.Add(50 * time.Millisecond)) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("expect 5 tokens refilled in 50ms, got %d", allowed)
+	}
+	now = now.Add(time.Hour)
+	allowed = 0
+	for i := 0; i < 20; i++ {
+		if b.getToken(now) {
+			allowed++
+		}
+	}
+	if allowed != 11 {
+		t.Fatalf("tokens should not exceed capacity, got %d", allowed)
+	}
+}
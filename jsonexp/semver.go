@@ -0,0 +1,209 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 语义化版本号，如 1.10.0, v2.3.1-beta.2
+// 主版本部分可以有任意多段(1.2, 1.2.3.4均合法)，缺失的段按0处理
+type semVersion struct {
+	numbers    []int64
+	preRelease []string
+}
+
+func parseSemVersion(s string) (*semVersion, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty version")
+	}
+	if s[0] == 'v' || s[0] == 'V' {
+		s = s[1:]
+	}
+	// build metadata不参与比较
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	ret := &semVersion{}
+	if i := strings.Index(s, "-"); i >= 0 {
+		if i == len(s)-1 {
+			return nil, fmt.Errorf("invalid version %s", s)
+		}
+		ret.preRelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %s", s)
+		}
+		ret.numbers = append(ret.numbers, n)
+	}
+	return ret, nil
+}
+
+// 返回 -1, 0, 1
+func (m *semVersion) compare(other *semVersion) int {
+	n := len(m.numbers)
+	if len(other.numbers) > n {
+		n = len(other.numbers)
+	}
+	for i := 0; i < n; i++ {
+		var a, b int64
+		if i < len(m.numbers) {
+			a = m.numbers[i]
+		}
+		if i < len(other.numbers) {
+			b = other.numbers[i]
+		}
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	}
+
+	// 带预发布标识的版本小于正式版本
+	if len(m.preRelease) == 0 && len(other.preRelease) == 0 {
+		return 0
+	} else if len(m.preRelease) == 0 {
+		return 1
+	} else if len(other.preRelease) == 0 {
+		return -1
+	}
+	for i := 0; i < len(m.preRelease) && i < len(other.preRelease); i++ {
+		if r := comparePreReleasePart(m.preRelease[i], other.preRelease[i]); r != 0 {
+			return r
+		}
+	}
+	if len(m.preRelease) < len(other.preRelease) {
+		return -1
+	} else if len(m.preRelease) > len(other.preRelease) {
+		return 1
+	}
+	return 0
+}
+
+func comparePreReleasePart(a, b string) int {
+	ia, errA := strconv.ParseInt(a, 10, 64)
+	ib, errB := strconv.ParseInt(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if ia < ib {
+			return -1
+		} else if ia > ib {
+			return 1
+		}
+		return 0
+	case errA == nil:
+		// 数字标识小于字母标识
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func getSemVersionValue(v interface{}) (*semVersion, bool) {
+	s, ok := GetStringValue(v)
+	if !ok {
+		return nil, false
+	}
+	ret, err := parseSemVersion(s)
+	if err != nil {
+		return nil, false
+	}
+	return ret, true
+}
+
+// 比较L和R两个版本号
+func compareVersion(L, R interface{}) (int, error) {
+	l, ok := getSemVersionValue(L)
+	if !ok {
+		return 0, fmt.Errorf("invalid param L, not a version")
+	}
+	r, ok := getSemVersionValue(R)
+	if !ok {
+		return 0, fmt.Errorf("right value not version-incompatible")
+	}
+	return l.compare(r), nil
+}
+
+// 将between的右值拆分为两个边界，支持"a,b"形式的字符串或者长度为2的数组
+func splitBetweenBounds(R interface{}) (interface{}, interface{}, bool) {
+	if list, ok := R.([]interface{}); ok {
+		if len(list) != 2 {
+			return nil, nil, false
+		}
+		return list[0], list[1], true
+	}
+	r, ok := GetStringValue(R)
+	if !ok {
+		return nil, nil, false
+	}
+	rList := strings.Split(r, ",")
+	if len(rList) != 2 {
+		return nil, nil, false
+	}
+	return strings.TrimSpace(rList[0]), strings.TrimSpace(rList[1]), true
+}
+
+var VersionMore = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := compareVersion(L, R)
+	return err == nil && ret > 0, err
+}
+
+var VersionMoreEqual = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := compareVersion(L, R)
+	return err == nil && ret >= 0, err
+}
+
+var VersionLess = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := compareVersion(L, R)
+	return err == nil && ret < 0, err
+}
+
+var VersionLessEqual = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := compareVersion(L, R)
+	return err == nil && ret <= 0, err
+}
+
+var VersionEqual = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := compareVersion(L, R)
+	return err == nil && ret == 0, err
+}
+
+var VersionNotEqual = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := compareVersion(L, R)
+	return err == nil && ret != 0, err
+}
+
+var VersionBetween = func(L, R interface{}, context Context) (bool, error) {
+	b, e, ok := splitBetweenBounds(R)
+	if !ok {
+		return false, fmt.Errorf("right value not a between string")
+	}
+	retB, err := compareVersion(L, b)
+	if err != nil {
+		return false, err
+	}
+	retE, err := compareVersion(L, e)
+	if err != nil {
+		return false, err
+	}
+	return retB >= 0 && retE <= 0, nil
+}
+
+var VersionNotBetween = func(L, R interface{}, context Context) (bool, error) {
+	ret, err := VersionBetween(L, R, context)
+	if err != nil {
+		return false, err
+	}
+	return !ret, nil
+}
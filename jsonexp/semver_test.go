@@ -0,0 +1,51 @@
+package jsonexp
+
+import (
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestVersionCompare(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$app_ver", nil)
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$app_ver", "1.10.0")
+
+	cases := []struct {
+		op    string
+		right interface{}
+		want  bool
+	}{
+		{"ver>", "1.9.0", true},
+		{"ver>=", "1.10", true},
+		{"ver<", "v1.10.1", true},
+		{"ver=", "1.10.0.0", true},
+		{"ver!=", "1.10.0", false},
+		{"ver>", "1.10.0-beta.2", true},
+		{"verbetween", "1.9.9,2.0.0", true},
+		{"verbetween", []interface{}{"1.0", "1.9"}, false},
+		{"^verbetween", "1.0,1.9", true},
+	}
+	for _, c := range cases {
+		ret, err := dict.Compare(c.op, "$app_ver", c.right, ctx)
+		if err != nil {
+			t.Fatalf("%s %v: %s", c.op, c.right, err.Error())
+		}
+		if ret != c.want {
+			t.Fatalf("1.10.0 %s %v, expect %v", c.op, c.right, c.want)
+		}
+	}
+
+	for _, op := range []string{"ver>", "ver>=", "ver<", "ver<=", "ver="} {
+		if ret, err := dict.Compare(op, "$app_ver", "abc", ctx); err == nil || ret {
+			t.Fatalf("%s abc: expect false and error for invalid version", op)
+		}
+	}
+	if ret, _ := VersionLess("1.0.0-alpha", "1.0.0-alpha.1", nil); !ret {
+		t.Fatalf("1.0.0-alpha < 1.0.0-alpha.1 expected")
+	}
+	if ret, _ := VersionLess("1.0.0-alpha.beta", "1.0.0-beta", nil); !ret {
+		t.Fatalf("1.0.0-alpha.beta < 1.0.0-beta expected")
+	}
+}
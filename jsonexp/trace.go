@@ -0,0 +1,133 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 上下文中保存执行跟踪的键
+const ContextKeyTrace = "__JSONEXP_TRACE__"
+
+const (
+	TraceEventVar     = "var"
+	TraceEventNode    = "node"
+	TraceEventCompare = "compare"
+	TraceEventAssign  = "assign"
+)
+
+// 变量值的来源
+const (
+	VarSourceContext = "context" // 来自上下文
+	VarSourceFunc    = "func"    // 调用VarFunc求值
+	VarSourceCache   = "cache"   // 来自VarFunc的缓存
+	VarSourceDefault = "default" // 来自变量声明的默认值
+	VarSourceObject  = "object"  // 对象属性
+	VarSourceNone    = "none"    // 变量没有值，也没有声明默认值
+)
+
+type TraceEvent struct {
+	Type      string      `json:"type"`
+	NodeIndex int         `json:"node"`
+	Name      string      `json:"name"`
+	Operator  string      `json:"op,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	Source    string      `json:"source,omitempty"`
+	Result    bool        `json:"result"`
+	Error     string      `json:"error,omitempty"`
+	Skipped   string      `json:"skipped,omitempty"` // 节点被跳过的原因，见NodeSkipDisabled等
+}
+
+// 执行跟踪，记录一次(或多次)执行过程中变量的求值、节点的匹配、比较与赋值
+type Trace struct {
+	Events []*TraceEvent `json:"events"`
+	node   int
+}
+
+// 在上下文中开启执行跟踪
+func EnableTrace(context Context) *Trace {
+	if ret := GetTrace(context); ret != nil {
+		return ret
+	}
+	ret := &Trace{node: -1}
+	context.SetCtxData(ContextKeyTrace, ret)
+	return ret
+}
+
+// 获取上下文中的执行跟踪，未开启时返回nil
+func GetTrace(context Context) *Trace {
+	if context == nil {
+		return nil
+	}
+	if v, ok := context.GetCtxData(ContextKeyTrace); ok {
+		if ret, ok := v.(*Trace); ok {
+			return ret
+		}
+	}
+	return nil
+}
+
+func (m *Trace) add(event *TraceEvent) {
+	event.NodeIndex = m.node
+	m.Events = append(m.Events, event)
+}
+
+func (m *Trace) String() string {
+	var sb strings.Builder
+	for _, e := range m.Events {
+		switch e.Type {
+		case TraceEventNode:
+			fmt.Fprintf(&sb, "node %d", e.NodeIndex)
+			if e.Name != "" {
+				fmt.Fprintf(&sb, " (%s)", e.Name)
+			}
+			if e.Skipped != "" {
+				fmt.Fprintf(&sb, ": skipped=%s", e.Skipped)
+			} else {
+				fmt.Fprintf(&sb, ": matched=%v", e.Result)
+			}
+		case TraceEventVar:
+			fmt.Fprintf(&sb, "  var %s = %v (%s)", e.Name, e.Value, e.Source)
+		default:
+			fmt.Fprintf(&sb, "  %s %s %s %v => %v", e.Type, e.Name, e.Operator, e.Value, e.Result)
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&sb, " error: %s", e.Error)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func traceVar(context Context, name string, value interface{}, source string, err error) {
+	if trace := GetTrace(context); trace != nil {
+		event := &TraceEvent{Type: TraceEventVar, Name: name, Value: value, Source: source, Result: err == nil}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		trace.add(event)
+	}
+}
+
+func traceNode(context Context, nodeIndex int, name string) *TraceEvent {
+	if trace := GetTrace(context); trace != nil {
+		trace.node = nodeIndex
+		event := &TraceEvent{Type: TraceEventNode, Name: name}
+		trace.add(event)
+		return event
+	}
+	return nil
+}
+
+func traceOperation(context Context, tp string, left string, op string, right interface{}, result bool, err error) {
+	if trace := GetTrace(context); trace != nil {
+		event := &TraceEvent{Type: tp, Name: left, Operator: op, Value: right, Result: result}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		trace.add(event)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+// 可选接口，对象实现该接口后，在事务上下文(TxContext)中对其属性的赋值可以被回滚。
+// PropertyUndoHook在SetPropertyValue之前被调用，返回的函数用于撤销本次写入，返回nil表示无需撤销
+type UndoableObject interface {
+	Object
+	PropertyUndoHook(property string, context Context) (undo func())
+}
+
+type txEntry struct {
+	key      string
+	oldValue interface{}
+	existed  bool
+	undo     func() // 对象属性写入的回滚函数
+}
+
+// 事务上下文，包装一个Context，记录期间发生的所有写入，可以提交或者回滚。
+// 写入直接作用于被包装的Context(读取可见)，回滚时按相反顺序恢复。
+// TxContext不是线程安全的，与jsonexp的执行一样，一个TxContext只应在一个goroutine中使用
+type TxContext struct {
+	parent  Context
+	journal []txEntry
+}
+
+func NewTxContext(parent Context) *TxContext {
+	return &TxContext{parent: parent}
+}
+
+// 被包装的Context
+func (m *TxContext) Parent() Context {
+	return m.parent
+}
+
+func (m *TxContext) GetCtxData(key string) (interface{}, bool) {
+	return m.parent.GetCtxData(key)
+}
+
+func (m *TxContext) SetCtxData(key string, value interface{}) {
+	if key == "" {
+		return
+	}
+	old, existed := m.parent.GetCtxData(key)
+	m.journal = append(m.journal, txEntry{key: key, oldValue: old, existed: existed})
+	m.parent.SetCtxData(key, value)
+}
+
+func (m *TxContext) RemoveCtxData(key string) {
+	if key == "" {
+		return
+	}
+	old, existed := m.parent.GetCtxData(key)
+	if !existed {
+		return
+	}
+	m.journal = append(m.journal, txEntry{key: key, oldValue: old, existed: true})
+	m.parent.RemoveCtxData(key)
+}
+
+// 记录一个回滚函数，用于对象属性等不经过SetCtxData的写入
+func (m *TxContext) RecordUndo(undo func()) {
+	if undo == nil {
+		return
+	}
+	m.journal = append(m.journal, txEntry{undo: undo})
+}
+
+// 返回当前的保存点，可用于RollbackTo
+func (m *TxContext) Savepoint() int {
+	return len(m.journal)
+}
+
+// 回滚到保存点savepoint，之后的写入被撤销
+func (m *TxContext) RollbackTo(savepoint int) {
+	if savepoint < 0 {
+		savepoint = 0
+	}
+	for i := len(m.journal) - 1; i >= savepoint; i-- {
+		entry := m.journal[i]
+		if entry.undo != nil {
+			entry.undo()
+		} else if entry.existed {
+			m.parent.SetCtxData(entry.key, entry.oldValue)
+		} else {
+			m.parent.RemoveCtxData(entry.key)
+		}
+	}
+	if savepoint < len(m.journal) {
+		m.journal = m.journal[:savepoint]
+	}
+}
+
+// 撤销所有未提交的写入
+func (m *TxContext) Rollback() {
+	m.RollbackTo(0)
+}
+
+// 提交所有写入。如果被包装的Context也是一个TxContext，对象属性的回滚函数转交给它，
+// 使外层事务仍然可以回滚
+func (m *TxContext) Commit() {
+	if parentTx, ok := m.parent.(*TxContext); ok {
+		for _, entry := range m.journal {
+			if entry.undo != nil {
+				parentTx.RecordUndo(entry.undo)
+			}
+		}
+	}
+	m.journal = m.journal[:0]
+}
+
+// 未提交的写入数量
+func (m *TxContext) PendingWrites() int {
+	return len(m.journal)
+}
@@ -0,0 +1,105 @@
+package jsonexp
+
+import (
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+type undoableObj struct {
+	props map[string]interface{}
+}
+
+func (m *undoableObj) GetPropertyValue(property string, context Context) interface{} {
+	return m.props[property]
+}
+
+func (m *undoableObj) SetPropertyValue(property string, value interface{}, context Context) {
+	m.props[property] = value
+}
+
+func (m *undoableObj) PropertyUndoHook(property string, context Context) func() {
+	old, existed := m.props[property]
+	return func() {
+		if existed {
+			m.props[property] = old
+		} else {
+			delete(m.props, property)
+		}
+	}
+}
+
+func TestTxContext(t *testing.T) {
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$a", 1)
+	tx := NewTxContext(ctx)
+	tx.SetCtxData("$a", 2)
+	tx.SetCtxData("$b", "x")
+	sp := tx.Savepoint()
+	tx.RemoveCtxData("$a")
+	if _, ok := ctx.GetCtxData("$a"); ok {
+		t.Fatalf("write should be visible in parent")
+	}
+	tx.RollbackTo(sp)
+	if v, _ := ctx.GetCtxData("$a"); v != 2 {
+		t.Fatalf("rollback to savepoint fail, %v", v)
+	}
+	tx.Rollback()
+	if v, _ := ctx.GetCtxData("$a"); v != 1 {
+		t.Fatalf("rollback fail, %v", v)
+	}
+	if _, ok := ctx.GetCtxData("$b"); ok {
+		t.Fatalf("rollback fail, $b exists")
+	}
+}
+
+func TestJsonExpGroupTransactional(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$a", nil)
+	dict.RegisterVar("$b", nil)
+	obj := &undoableObj{props: map[string]interface{}{"icon": "old.jpg"}}
+	dict.RegisterObject("$resp", obj)
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[
+				["$a", "=", 10]
+			],
+			[
+				[
+					["$b", "=", 5],
+					["$resp.icon", "=", "new.jpg"],
+					["$a", "/=", 0]
+				]
+			]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	g.SetTransactional(true)
+	ctx := &goutil.DefaultContext{}
+	if err := g.Execute(ctx); err == nil {
+		t.Fatalf("expect divide by zero error")
+	}
+	if v, _ := ctx.GetCtxData("$a"); v != float64(10) {
+		t.Fatalf("node 1 should be committed, $a = %v", v)
+	}
+	if _, ok := ctx.GetCtxData("$b"); ok {
+		t.Fatalf("node 2 should be rolled back")
+	}
+	if obj.props["icon"] != "old.jpg" {
+		t.Fatalf("object property should be rolled back, %v", obj.props["icon"])
+	}
+
+	// 外层事务回滚整个组
+	ctx = &goutil.DefaultContext{}
+	tx := NewTxContext(ctx)
+	if err := g.Execute(tx); err == nil {
+		t.Fatalf("expect divide by zero error")
+	}
+	tx.Rollback()
+	if _, ok := ctx.GetCtxData("$a"); ok {
+		t.Fatalf("group should be rolled back")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+)
+
+// bool、null和列表在运算中的转换规则:
+//
+//	=、!=                任一侧为null时，两侧都为null才相等，不存在的变量视为null;
+//	                     任一侧为bool时，另一侧转换为bool后比较: 字符串按strconv.ParseBool转换，数值非0为true，无法转换时报错;
+//	                     任一侧为列表时，另一侧转换为列表(字符串视为逗号分隔的集合)后按顺序逐个比较元素
+//	>、>=、<、<=、between  只能比较字符串和数值，任一侧为null、bool或列表时报错
+//	~、~*、*~、cv         操作数为列表时报错(cv的右值可以是列表，视为集合)，列表使用has、any、none
+//	in、not in           左值为null时，右值中包含null才成立; 左值为bool时与右值中的true/false或"true"/"false"比较; 左值不能是列表
+//	+=、-=、*=、/=、%=     左值为null时视为右值类型的零值; 操作数为bool或列表、右值为null时报错，列表使用append/remove
+//	min、max             左值为null时直接取右值; 操作数为bool或列表、右值为null时报错
+//
+// bool转换为字符串时为"true"/"false"(例如在宏中)，不会隐式转换为数值，需要时可以将变量声明为int类型
+
+// 按bool、null和列表的规则判断是否相等，handled为false时由调用方按字符串和数值比较
+func equalValues(L, R interface{}) (equal bool, handled bool, err error) {
+	lType, rType := GetValueType(L), GetValueType(R)
+	switch {
+	case lType == VarNull || rType == VarNull:
+		return lType == rType, true, nil
+	case lType == VarBool || rType == VarBool:
+		l, err := CoerceValue(L, VarBool)
+		if err != nil {
+			return false, true, fmt.Errorf("can not compare %v with bool", L)
+		}
+		r, err := CoerceValue(R, VarBool)
+		if err != nil {
+			return false, true, fmt.Errorf("can not compare %v with bool", R)
+		}
+		return l.(bool) == r.(bool), true, nil
+	case lType == VarSlice || rType == VarSlice:
+		l, ok := GetListValue(L)
+		if !ok {
+			return false, true, fmt.Errorf("can not compare %v with list", L)
+		}
+		r, ok := GetListValue(R)
+		if !ok {
+			return false, true, fmt.Errorf("can not compare %v with list", R)
+		}
+		if len(l) != len(r) {
+			return false, true, nil
+		}
+		// 元素按相同的规则比较，字符串和数值元素按listItemKey比较，因此1与"1"相等
+		for i := range l {
+			eq, handled, err := equalValues(l[i], r[i])
+			if !handled {
+				eq = listItemKey(l[i]) == listItemKey(r[i])
+			}
+			if err != nil || !eq {
+				return false, true, nil
+			}
+		}
+		return true, true, nil
+	}
+	return false, false, nil
+}
+
+// 大小比较的操作数只能是字符串和数值
+func checkOrderedOperands(L, R interface{}) error {
+	for _, v := range []interface{}{L, R} {
+		switch tp := GetValueType(v); tp {
+		case VarNull, VarBool, VarSlice:
+			return fmt.Errorf("%s value is not comparable", tp.String())
+		}
+	}
+	return nil
+}
+
+// 字符串匹配(cv、~、~*、*~、正则)的操作数不能是列表，rightList为true时右值可以是列表(cv的右值为集合)
+func checkStringOperands(L, R interface{}, rightList bool) error {
+	if GetValueType(L) == VarSlice {
+		return fmt.Errorf("invalid L, list value, use has, any or none instead")
+	}
+	if !rightList && GetValueType(R) == VarSlice {
+		return fmt.Errorf("invalid R, list value")
+	}
+	return nil
+}
+
+// 算术赋值的操作数只能是数值或者可以转换为数值的字符串，左值可以为null
+func checkArithmeticOperands(lValue, R interface{}) error {
+	if tp := GetValueType(lValue); tp == VarBool || tp == VarSlice {
+		return fmt.Errorf("invalid operand, %s value of left", tp.String())
+	}
+	if tp := GetValueType(R); tp == VarNull || tp == VarBool || tp == VarSlice {
+		return fmt.Errorf("invalid operand, %s value of right", tp.String())
+	}
+	if _, ok := GetFloatValue(lValue); !ok {
+		return fmt.Errorf("invalid operand %v", lValue)
+	}
+	if _, ok := GetFloatValue(R); !ok {
+		return fmt.Errorf("invalid operand %v", R)
+	}
+	return nil
+}
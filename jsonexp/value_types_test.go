@@ -0,0 +1,187 @@
+package jsonexp
+
+import (
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestValueTypes(t *testing.T) {
+	cases := map[VarType]interface{}{
+		VarNull:  nil,
+		VarBool:  true,
+		VarStr:   "a",
+		VarInt:   int64(1),
+		VarFloat: 1.5,
+		VarSlice: []interface{}{1},
+	}
+	for tp, v := range cases {
+		if got := GetValueType(v); got != tp {
+			t.Fatalf("type of %v = %s, expect %s", v, got.String(), tp.String())
+		}
+	}
+	if s, ok := GetStringValue(false); !ok || s != "false" {
+		t.Fatalf("string of false = %s", s)
+	}
+	if _, ok := GetIntValue(true); ok {
+		t.Fatalf("bool should not convert to int implicitly")
+	}
+}
+
+func TestCompareBoolNullList(t *testing.T) {
+	dict := NewDictionary()
+	for _, v := range []string{"$is_vip", "$flag", "$tags", "$name", "$ids", "$url"} {
+		dict.RegisterVar(v, nil)
+	}
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$is_vip", true)
+	ctx.SetCtxData("$flag", "0")
+	ctx.SetCtxData("$tags", []interface{}{"a", 1, true})
+	// 与JSON中的[1, 2.5]相同
+	ctx.SetCtxData("$ids", []interface{}{float64(1), 2.5})
+	ctx.SetCtxData("$url", "https://a.example.com")
+	cases := []struct {
+		compare string
+		left    string
+		right   interface{}
+		expect  bool
+		err     bool
+	}{
+		{"=", "$is_vip", true, true, false},
+		{"=", "$is_vip", "true", true, false},
+		{"!=", "$is_vip", false, true, false},
+		{"=", "$flag", false, true, false},
+		{"=", "$is_vip", 1, true, false},
+		{"=", "$is_vip", "yes", false, true},
+		{"=", "$name", nil, true, false},
+		{"!=", "$name", nil, false, false},
+		{"=", "$name", "", false, false},
+		{"=", "$is_vip", nil, false, false},
+		{"=", "$tags", []interface{}{"a", "1", "true"}, true, false},
+		{"=", "$tags", "a,1,true", true, false},
+		{"=", "$tags", []interface{}{1, "a", true}, false, false},
+		{"!=", "$tags", []interface{}{"a"}, true, false},
+		{">", "$is_vip", false, false, true},
+		{"<", "$name", 1, false, true},
+		{">=", "$tags", 1, false, true},
+		{"in", "$is_vip", []interface{}{false, true}, true, false},
+		{"in", "$is_vip", "true,false", true, false},
+		{"in", "$name", []interface{}{"", "a"}, false, false},
+		{"in", "$name", []interface{}{nil, "a"}, true, false},
+		{"not in", "$name", []interface{}{"a"}, true, false},
+		{"in", "$tags", []interface{}{"a"}, false, true},
+		{"=", "$ids", []interface{}{"1", "2.5"}, true, false},
+		{"=", "$ids", "1,2.5", true, false},
+		{"!=", "$ids", []interface{}{1, 2.5}, false, false},
+		{"~", "$tags", "a", false, true},
+		{"^~", "$tags", "a", false, true},
+		{"~*", "$tags", "a", false, true},
+		{"^~*", "$tags", "a", false, true},
+		{"*~", "$tags", "a", false, true},
+		{"^*~", "$tags", "a", false, true},
+		{"cv", "$tags", "a", false, true},
+		{"^cv", "$tags", "a", false, true},
+		{"~", "$url", []interface{}{"example"}, false, true},
+		{"cv", "$url", []interface{}{"foo", "example"}, true, false},
+		{"^cv", "$url", []interface{}{"foo", "bar"}, true, false},
+		{"*~", "$url", ".com", true, false},
+	}
+	for _, c := range cases {
+		got, err := dict.Compare(c.compare, c.left, c.right, ctx)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s %s %v, expect error", c.left, c.compare, c.right)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s %s %v, %s", c.left, c.compare, c.right, err.Error())
+		}
+		if got != c.expect {
+			t.Fatalf("%s %s %v = %v, expect %v", c.left, c.compare, c.right, got, c.expect)
+		}
+	}
+}
+
+func TestBoolConfiguration(t *testing.T) {
+	dict := NewDictionary()
+	for _, v := range []string{"$is_vip", "$discount", "$reason"} {
+		dict.RegisterVar(v, nil)
+	}
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$is_vip", "=", true], ["$discount", "=", 0.8]],
+			[["$reason", "=", null], ["$reason", "=", "none"]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$is_vip", true)
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$discount"); v != 0.8 {
+		t.Fatalf("$discount = %v", v)
+	}
+	if v, _ := ctx.GetCtxData("$reason"); v != "none" {
+		t.Fatalf("$reason = %v", v)
+	}
+}
+
+func TestAssignBoolNullList(t *testing.T) {
+	dict := NewDictionary()
+	for _, v := range []string{"$flag", "$tags", "$n", "$s", "$empty"} {
+		dict.RegisterVar(v, nil)
+	}
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$flag", true)
+	ctx.SetCtxData("$tags", []interface{}{"a"})
+	ctx.SetCtxData("$n", int64(2))
+	ctx.SetCtxData("$s", "x")
+	errCases := [][]interface{}{
+		{"+=", "$flag", 1},
+		{"-=", "$flag", 1},
+		{"*=", "$tags", 2},
+		{"/=", "$n", true},
+		{"%=", "$n", nil},
+		{"+=", "$s", true},
+		{"+=", "$n", []interface{}{1}},
+		{"max=", "$n", nil},
+		{"min=", "$flag", false},
+	}
+	for _, c := range errCases {
+		if err := dict.Assign(c[0].(string), c[1].(string), c[2], ctx); err == nil {
+			t.Fatalf("%s %s %v, expect error", c[1], c[0], c[2])
+		}
+	}
+	if err := dict.Assign("+=", "$empty", 3, ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$empty"); v != int64(3) {
+		t.Fatalf("$empty = %#v", v)
+	}
+	if err := dict.Assign("-=", "$n", 1, ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$n"); v != int64(1) {
+		t.Fatalf("$n = %#v", v)
+	}
+	if err := dict.Assign("=", "$flag", false, ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$flag"); v != false {
+		t.Fatalf("$flag = %#v", v)
+	}
+}
+
+func TestRegExpMatchList(t *testing.T) {
+	if _, err := RegExpMatch([]interface{}{"a", "b"}, "a", nil); err == nil {
+		t.Fatalf("expect error for list operand")
+	}
+	if ok, err := RegExpMatch("abc", "^a", nil); err != nil || !ok {
+		t.Fatalf("regexp match fail, %v", err)
+	}
+}
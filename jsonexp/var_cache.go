@@ -0,0 +1,163 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 上下文中保存变量缓存的键
+const ContextKeyVarCache = "__JSONEXP_VAR_CACHE__"
+
+// VarFunc的缓存策略
+type VarCachePolicy uint
+
+const (
+	VarCacheNone       VarCachePolicy = iota // 每次引用变量都调用VarFunc
+	VarCachePerContext                       // 同一个上下文中只调用一次VarFunc
+	VarCacheTTL                              // 跨上下文缓存，过期后重新调用VarFunc
+)
+
+type varCacheEntry struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+type varCache struct {
+	policy VarCachePolicy
+	ttl    time.Duration
+
+	// VarCacheTTL
+	lock  sync.RWMutex
+	entry *varCacheEntry
+}
+
+// 注册变量并指定VarFunc的缓存策略，policy为VarCacheTTL时ttl必须大于0
+func (m *Dictionary) RegisterVarWithCache(varName string, fetchFunc VarFunc, policy VarCachePolicy, ttl time.Duration) error {
+	if policy == VarCacheTTL && ttl <= 0 {
+		return fmt.Errorf("invalid ttl")
+	}
+	if err := m.RegisterVar(varName, fetchFunc); err != nil {
+		return err
+	}
+	m.varCacheListLock.Lock()
+	defer m.varCacheListLock.Unlock()
+	m.changed()
+	if policy == VarCacheNone {
+		delete(m.varCacheList, varName)
+	} else {
+		m.varCacheList[varName] = &varCache{policy: policy, ttl: ttl}
+	}
+	return nil
+}
+
+func (m *Dictionary) getVarCache(varName string) (*varCache, bool) {
+	defer m.rlock(&m.varCacheListLock)()
+	ret, ok := m.varCacheList[varName]
+	return ret, ok
+}
+
+// 清除TTL缓存，varName为空时清除所有变量的缓存
+func (m *Dictionary) ClearVarCache(varName string) {
+	defer m.rlock(&m.varCacheListLock)()
+	for k, v := range m.varCacheList {
+		if varName == "" || k == varName {
+			v.lock.Lock()
+			v.entry = nil
+			v.lock.Unlock()
+		}
+	}
+}
+
+// 上下文中的VarCachePerContext缓存，同一个上下文可能被并发执行
+type contextVarCache struct {
+	lock   sync.RWMutex
+	values map[string]interface{}
+}
+
+func (m *contextVarCache) get(varName string) (interface{}, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret, ok := m.values[varName]
+	return ret, ok
+}
+
+func (m *contextVarCache) set(varName string, value interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[varName] = value
+}
+
+// 创建上下文缓存时加锁，避免并发创建时相互覆盖
+var contextVarCacheCreateLock sync.Mutex
+
+func getContextVarCache(context Context, create bool) *contextVarCache {
+	if context == nil {
+		return nil
+	}
+	if v, ok := context.GetCtxData(ContextKeyVarCache); ok {
+		if ret, ok := v.(*contextVarCache); ok {
+			return ret
+		}
+	}
+	if !create {
+		return nil
+	}
+	contextVarCacheCreateLock.Lock()
+	defer contextVarCacheCreateLock.Unlock()
+	if v, ok := context.GetCtxData(ContextKeyVarCache); ok {
+		if ret, ok := v.(*contextVarCache); ok {
+			return ret
+		}
+	}
+	ret := &contextVarCache{values: make(map[string]interface{})}
+	context.SetCtxData(ContextKeyVarCache, ret)
+	return ret
+}
+
+// 按照变量的缓存策略调用VarFunc，返回值的来源为VarSourceFunc或VarSourceCache
+func (m *Dictionary) callVarFunc(varName string, fn VarFunc, context Context) (interface{}, string, error) {
+	cache, ok := m.getVarCache(varName)
+	if !ok {
+		ret, err := fn(context)
+		return ret, VarSourceFunc, err
+	}
+
+	switch cache.policy {
+	case VarCachePerContext:
+		if ctxCache := getContextVarCache(context, false); ctxCache != nil {
+			if ret, ok := ctxCache.get(varName); ok {
+				return ret, VarSourceCache, nil
+			}
+		}
+		ret, err := fn(context)
+		if err == nil {
+			if ctxCache := getContextVarCache(context, true); ctxCache != nil {
+				ctxCache.set(varName, ret)
+			}
+		}
+		return ret, VarSourceFunc, err
+	case VarCacheTTL:
+		now := m.Now()
+		cache.lock.RLock()
+		entry := cache.entry
+		cache.lock.RUnlock()
+		if entry != nil && now.Before(entry.expireAt) {
+			return entry.value, VarSourceCache, nil
+		}
+		ret, err := fn(context)
+		if err == nil {
+			cache.lock.Lock()
+			cache.entry = &varCacheEntry{value: ret, expireAt: now.Add(cache.ttl)}
+			cache.lock.Unlock()
+		}
+		return ret, VarSourceFunc, err
+	default:
+		ret, err := fn(context)
+		return ret, VarSourceFunc, err
+	}
+}
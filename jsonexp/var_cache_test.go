@@ -0,0 +1,122 @@
+package jsonexp
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/truexf/goutil"
+)
+
+func TestVarCache(t *testing.T) {
+	dict := NewDictionary()
+	now := time.Now()
+	dict.SetClock(func() time.Time { return now })
+	calls := map[string]int{}
+	counter := func(name string) VarFunc {
+		return func(context Context) (interface{}, error) {
+			calls[name]++
+			return "chrome", nil
+		}
+	}
+	dict.RegisterVar("$ua_none", counter("none"))
+	dict.RegisterVarWithCache("$ua_ctx", counter("ctx"), VarCachePerContext, 0)
+	dict.RegisterVarWithCache("$ua_ttl", counter("ttl"), VarCacheTTL, time.Minute)
+	if err := dict.RegisterVarWithCache("$bad", counter("bad"), VarCacheTTL, 0); err == nil {
+		t.Fatalf("expect invalid ttl error")
+	}
+
+	cfg, err := NewConfiguration([]byte(`{
+		"g": [
+			[["$ua_none", "=", "chrome"], ["$ua_ctx", "=", "chrome"], ["$ua_ttl", "=", "chrome"], ["$x", "=", 1]],
+			[["$ua_none", "~", "chr"], ["$ua_ctx", "~", "chr"], ["$ua_ttl", "~", "chr"], ["$x", "+=", 1]]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	dict.RegisterVar("$x", nil)
+	g, _ := cfg.GetJsonExpGroup("g")
+
+	ctx := &goutil.DefaultContext{}
+	trace := EnableTrace(ctx)
+	g.Execute(ctx)
+	if calls["none"] != 2 || calls["ctx"] != 1 || calls["ttl"] != 1 {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+	cached := 0
+	for _, e := range trace.Events {
+		if e.Type == TraceEventVar && e.Source == VarSourceCache {
+			cached++
+		}
+	}
+	if cached != 2 {
+		t.Fatalf("expect 2 cached var events in trace, got %d\n%s", cached, trace.String())
+	}
+
+	g.Execute(&goutil.DefaultContext{})
+	if calls["ctx"] != 2 || calls["ttl"] != 1 {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+	now = now.Add(time.Minute * 2)
+	g.Execute(&goutil.DefaultContext{})
+	if calls["ttl"] != 2 {
+		t.Fatalf("ttl cache should expire: %v", calls)
+	}
+}
+
+// 并发执行共享同一个上下文，配合-race检查
+func TestVarCachePerContextConcurrent(t *testing.T) {
+	dict := NewDictionary()
+	var calls int64
+	dict.RegisterVarWithCache("$ua", func(context Context) (interface{}, error) {
+		atomic.AddInt64(&calls, 1)
+		return "chrome", nil
+	}, VarCachePerContext, 0)
+	ctx := &goutil.DefaultContext{WithLock: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if v, err := dict.GetVarValue("$ua", ctx); err != nil || v != "chrome" {
+					t.Errorf("$ua = %v, %v", v, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Fatalf("VarFunc called %d times in one context", n)
+	}
+}
+
+func TestVarSource(t *testing.T) {
+	dict := NewDictionary()
+	dict.RegisterVar("$a", nil)
+	cfg, err := NewConfiguration([]byte(`{
+		"vars": {"$b": {"type": "string", "default": "x"}},
+		"g": [[["$a", "=", null], ["$b", "=", "x"], ["$c", "=", 1]]]
+	}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	trace := EnableTrace(ctx)
+	if err := g.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string]string)
+	for _, e := range trace.Events {
+		if e.Type == TraceEventVar {
+			sources[e.Name] = e.Source
+		}
+	}
+	if sources["$a"] != VarSourceNone || sources["$b"] != VarSourceDefault {
+		t.Fatalf("unexpected sources %v", sources)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonexp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Configuration中的保留键，用于声明变量的类型和默认值:
+//
+//	"vars": {
+//		"$city": {"type": "string", "default": "unknown"},
+//		"$age": "int",
+//		"$tags": {"type": "list", "default": []}
+//	}
+const ConfigurationVarsKey = "vars"
+
+// 变量声明
+type VarDecl struct {
+	Name       string
+	Type       VarType
+	Default    interface{}
+	HasDefault bool
+}
+
+// 变量声明中类型名称与VarType的对应关系
+var varTypeNames = map[string]VarType{
+	"string": VarStr,
+	"int":    VarInt,
+	"float":  VarFloat,
+	"bool":   VarBool,
+	"list":   VarSlice,
+}
+
+func ParseVarType(name string) (VarType, bool) {
+	ret, ok := varTypeNames[strings.ToLower(strings.TrimSpace(name))]
+	return ret, ok
+}
+
+func (m VarType) String() string {
+	if m == VarNull {
+		return "null"
+	}
+	for k, v := range varTypeNames {
+		if v == m {
+			return k
+		}
+	}
+	return "invalid"
+}
+
+// 将v转换为tp类型
+func CoerceValue(v interface{}, tp VarType) (interface{}, error) {
+	switch tp {
+	case VarStr:
+		if v == nil {
+			return "", nil
+		}
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+		if GetValueType(v) == VarSlice {
+			return nil, fmt.Errorf("can not convert list to string")
+		}
+		if ret, ok := GetStringValue(v); ok {
+			return ret, nil
+		}
+	case VarInt:
+		if b, ok := v.(bool); ok {
+			if b {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		if GetValueType(v) == VarSlice {
+			return nil, fmt.Errorf("can not convert list to int")
+		}
+		if ret, ok := GetIntValue(v); ok {
+			return ret, nil
+		}
+	case VarFloat:
+		if b, ok := v.(bool); ok {
+			if b {
+				return float64(1), nil
+			}
+			return float64(0), nil
+		}
+		if GetValueType(v) == VarSlice {
+			return nil, fmt.Errorf("can not convert list to float")
+		}
+		if ret, ok := GetFloatValue(v); ok {
+			return ret, nil
+		}
+	case VarBool:
+		if v == nil {
+			return false, nil
+		}
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		switch GetValueType(v) {
+		case VarStr:
+			if ret, err := strconv.ParseBool(strings.TrimSpace(v.(string))); err == nil {
+				return ret, nil
+			}
+		case VarInt, VarFloat:
+			f, _ := GetFloatValue(v)
+			return f != 0, nil
+		}
+	case VarSlice:
+		// 与has/any等集合运算符保持一致，字符串视为逗号分隔的集合
+		if ret, ok := GetListValue(v); ok {
+			return ret, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid type")
+	}
+	return nil, fmt.Errorf("can not convert %v to %s", v, tp.String())
+}
+
+func parseVarDecl(name string, source interface{}) (*VarDecl, error) {
+	if len(name) <= 1 || name[0] != '$' {
+		return nil, fmt.Errorf("variable name must start with $")
+	}
+	ret := &VarDecl{Name: name}
+	typeName := ""
+	switch src := source.(type) {
+	case string:
+		typeName = src
+	case map[string]interface{}:
+		if tn, ok := src["type"].(string); ok {
+			typeName = tn
+		} else {
+			return nil, fmt.Errorf("type of variable %s is not declared", name)
+		}
+		ret.Default, ret.HasDefault = src["default"]
+	default:
+		return nil, fmt.Errorf("invalid declaration of variable %s", name)
+	}
+	tp, ok := ParseVarType(typeName)
+	if !ok {
+		return nil, fmt.Errorf("invalid type %s of variable %s", typeName, name)
+	}
+	ret.Type = tp
+	if ret.HasDefault {
+		dft, err := CoerceValue(ret.Default, tp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default value of variable %s, %s", name, err.Error())
+		}
+		ret.Default = dft
+	}
+	return ret, nil
+}
+
+func parseVarDecls(source interface{}) ([]*VarDecl, error) {
+	mp, ok := source.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not an object", ConfigurationVarsKey)
+	}
+	names := make([]string, 0, len(mp))
+	for k := range mp {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var ret []*VarDecl
+	for _, name := range names {
+		decl, err := parseVarDecl(name, mp[name])
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, decl)
+	}
+	return ret, nil
+}
+
+// 在字典中声明变量的类型和默认值，变量如未注册则自动注册。
+// 声明过类型的变量，在读取和赋值时都会被转换为声明的类型。
+// 配置中vars声明的变量不修改字典，只在执行该配置的表达式组时生效，见SetContextVarDecls
+func (m *Dictionary) DeclareVar(decl *VarDecl) error {
+	if decl == nil || len(decl.Name) <= 1 || decl.Name[0] != '$' {
+		return fmt.Errorf("invalid variable declaration")
+	}
+	if m.frozen {
+		return m.checkFrozenVarDecl(decl)
+	}
+	if _, ok := m.getVarFunc(decl.Name); !ok {
+		if err := m.RegisterVar(decl.Name, nil); err != nil {
+			return err
+		}
+	}
+	m.varDeclListLock.Lock()
+	defer m.varDeclListLock.Unlock()
+	m.varDeclList[decl.Name] = decl
+	m.changed()
+	return nil
+}
+
+func (m *Dictionary) GetVarDecl(varName string) (*VarDecl, bool) {
+	defer m.rlock(&m.varDeclListLock)()
+	ret, ok := m.varDeclList[varName]
+	return ret, ok
+}
+
+// 上下文中保存当前执行的配置的变量声明的键
+const ContextKeyVarDecls = "__JSONEXP_VAR_DECLS__"
+
+// 在上下文中设置执行期间生效的变量声明(变量名 => 声明)，返回恢复上下文的函数。
+// 配置中的表达式组执行时自动设置该配置的声明，生成的代码也通过它使用配置中的声明
+func SetContextVarDecls(context Context, decls map[string]*VarDecl) func() {
+	if context == nil || len(decls) == 0 {
+		return noUnlock
+	}
+	old, hasOld := context.GetCtxData(ContextKeyVarDecls)
+	context.SetCtxData(ContextKeyVarDecls, decls)
+	return func() {
+		if hasOld {
+			context.SetCtxData(ContextKeyVarDecls, old)
+		} else {
+			context.RemoveCtxData(ContextKeyVarDecls)
+		}
+	}
+}
+
+// 查找变量声明，上下文中当前执行的配置的声明优先于字典中的声明
+func (m *Dictionary) lookupVarDecl(varName string, context Context) (*VarDecl, bool) {
+	if context != nil {
+		if v, ok := context.GetCtxData(ContextKeyVarDecls); ok {
+			if decls, ok := v.(map[string]*VarDecl); ok {
+				if ret, ok := decls[varName]; ok {
+					return ret, true
+				}
+			}
+		}
+	}
+	return m.GetVarDecl(varName)
+}
+
+// 对声明了类型的变量值进行转换，未声明类型的变量原样返回
+func (m *Dictionary) coerceVarValue(varName string, value interface{}, context Context) (interface{}, error) {
+	decl, ok := m.lookupVarDecl(varName, context)
+	if !ok {
+		return value, nil
+	}
+	if value == nil && decl.HasDefault {
+		return decl.Default, nil
+	}
+	ret, err := CoerceValue(value, decl.Type)
+	if err != nil {
+		return nil, fmt.Errorf("variable %s, %s", varName, err.Error())
+	}
+	return ret, nil
+}
+
+// 检查表达式组中对声明了类型的变量的常量赋值是否合法
+func (m *JsonExpGroup) checkVarDecls(decls map[string]*VarDecl) error {
+	for _, exp := range m.group {
+		for _, assign := range exp.assignExpList {
+			decl, ok := decls[assign.Left]
+			if !ok || assign.AssignName != "=" {
+				continue
+			}
+			if s, ok := assign.Right.(string); ok && (len(s) > 1 && s[0] == '$' || hasMacro(s)) {
+				continue
+			}
+			if _, err := CoerceValue(assign.Right, decl.Type); err != nil {
+				return fmt.Errorf("node %s, assign %s: %s", exp.label(), assign.Left, err.Error())
+			}
+		}
+	}
+	return nil
+}
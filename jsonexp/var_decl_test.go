@@ -0,0 +1,132 @@
+package jsonexp
+
+import (
+	"testing"
+
+	"github.com/truexf/goutil"
+)
+
+func TestVarDecl(t *testing.T) {
+	dict := NewDictionary()
+	cfg, err := NewConfiguration([]byte(`{
+		"vars": {
+			"$age": "int",
+			"$city": {"type": "string", "default": "unknown"},
+			"$vip": {"type": "bool", "default": false},
+			"$tags": {"type": "list"}
+		},
+		"g": [
+			[
+				["$age", ">", 9],
+				["$city", "=", "shanghai"]
+			],
+			[
+				["$city", "=", "unknown"],
+				["$vip", "=", "true"]
+			],
+			[
+				["$tags", "=", "a,b"]
+			]
+		]
+	}`), dict)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := cfg.GetNameValue(ConfigurationVarsKey, nil); ok {
+		t.Fatalf("vars should not be a name value")
+	}
+	if decl, ok := cfg.GetVarDecl("$age"); !ok || decl.Type != VarInt {
+		t.Fatalf("$age not declared as int")
+	}
+	if _, ok := dict.GetVarDecl("$age"); ok {
+		t.Fatalf("declarations of a configuration should not be added to the dictionary")
+	}
+	g, _ := cfg.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	ctx.SetCtxData("$age", "10")
+	if err := g.Execute(ctx); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v, _ := ctx.GetCtxData("$city"); v != "shanghai" {
+		t.Fatalf("$age should be coerced to int, $city = %v", v)
+	}
+	if v, _ := ctx.GetCtxData("$vip"); v != nil {
+		t.Fatalf("$vip = %v", v)
+	}
+	if v, _ := ctx.GetCtxData("$tags"); len(v.([]interface{})) != 2 {
+		t.Fatalf("$tags = %v", v)
+	}
+	if _, ok := ctx.GetCtxData(ContextKeyVarDecls); ok {
+		t.Fatalf("declarations should be removed from context after execution")
+	}
+
+	ctx = &goutil.DefaultContext{}
+	g.Execute(ctx)
+	if v, _ := ctx.GetCtxData("$vip"); v != true {
+		t.Fatalf("$vip should be assigned with bool, %#v", v)
+	}
+
+	cfg, err = NewConfiguration([]byte(`{
+		"vars": {"$age": "int"},
+		"g": [[["$age", "=", "$in"]]]
+	}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict.RegisterVar("$in", nil)
+	g, _ = cfg.GetJsonExpGroup("g")
+	ctx = &goutil.DefaultContext{}
+	ctx.SetCtxData("$in", "abc")
+	if err := g.Execute(ctx); err == nil {
+		t.Fatalf("expect type error")
+	}
+	if _, ok := ctx.GetCtxData("$age"); ok {
+		t.Fatalf("failed assignment should be reverted")
+	}
+}
+
+// 同一个字典上的配置以不同的类型声明同名变量，互不影响
+func TestVarDeclPerConfiguration(t *testing.T) {
+	dict := NewDictionary()
+	cfgA, err := NewConfiguration([]byte(`{"vars": {"$x": "int"}, "g": [[["$x", "=", 1]]]}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgB, err := NewConfiguration([]byte(`{"vars": {"$x": "string"}, "g": [[["$x", "=", "b"]]]}`), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga, _ := cfgA.GetJsonExpGroup("g")
+	gb, _ := cfgB.GetJsonExpGroup("g")
+	ctx := &goutil.DefaultContext{}
+	if err := ga.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ctx.GetCtxData("$x"); v != int64(1) {
+		t.Fatalf("$x = %#v, expect int64(1)", v)
+	}
+	ctx = &goutil.DefaultContext{}
+	if err := gb.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ctx.GetCtxData("$x"); v != "b" {
+		t.Fatalf("$x = %#v", v)
+	}
+	if _, err := dict.GetVarValue("$x", ctx); err == nil {
+		t.Fatalf("$x should not be registered in the dictionary")
+	}
+}
+
+func TestVarDeclLoadError(t *testing.T) {
+	sources := []string{
+		`{"vars": {"$a": "date"}}`,
+		`{"vars": {"$a": {"type": "int", "default": "abc"}}}`,
+		`{"vars": {"a": "int"}}`,
+		`{"vars": {"$a": "int"}, "g": [[["$a", "=", "abc"]]]}`,
+	}
+	for _, src := range sources {
+		if _, err := NewConfiguration([]byte(src), NewDictionary()); err == nil {
+			t.Fatalf("expect error: %s", src)
+		}
+	}
+}
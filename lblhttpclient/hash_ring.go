@@ -0,0 +1,115 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	// 权重为1的后端在一致性hash环上的虚拟节点数
+	DefaultVirtualNodes int = 512
+	// 一致性hash环上虚拟节点的总数上限，避免权重较大时重建hash环(持有serverListLock的写锁)耗时过长
+	maxHashRingPoints int = 1 << 16
+)
+
+// 一致性hash环，MethodIpHash和MethodUrlParam使用。
+// 权重先除以所有权重的最大公约数，每个后端在环上放置weight*virtualNodes个虚拟节点，
+// 总数超过maxHashRingPoints时按比例减少(每个后端至少一个)。键映射到顺时针方向的第一个虚拟节点，
+// 增加或删除一个后端时只有约1/N的键改变映射。后端不可用(被摘除或者重试时已尝试过)时继续顺时针查找
+type hashRing struct {
+	points []ringPoint // 按hash排序
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *lblHttpBackend
+}
+
+func newHashRing(servers []*lblHttpBackend, virtualNodes int) *hashRing {
+	ret := &hashRing{}
+	var g, total int64
+	for _, backend := range servers {
+		g = gcd(g, backend.Weight())
+	}
+	for _, backend := range servers {
+		total += backend.Weight() / g * int64(virtualNodes)
+	}
+	for _, backend := range servers {
+		n := backend.Weight() / g * int64(virtualNodes)
+		if total > int64(maxHashRingPoints) {
+			if n = n * int64(maxHashRingPoints) / total; n < 1 {
+				n = 1
+			}
+		}
+		for i := 0; i < int(n); i++ {
+			// 虚拟节点以alias标识，后端的地址变化不影响映射
+			ret.points = append(ret.points, ringPoint{hash: hashKey(backend.alias + "#" + strconv.Itoa(i)), backend: backend})
+		}
+	}
+	sort.Slice(ret.points, func(i, j int) bool {
+		return ret.points[i].hash < ret.points[j].hash
+	})
+	return ret
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// 查找键对应的后端，servers为可以选择的后端
+func (m *hashRing) get(key string, servers []*lblHttpBackend, all bool) *lblHttpBackend {
+	if len(m.points) == 0 {
+		return nil
+	}
+	h := hashKey(key)
+	start := sort.Search(len(m.points), func(i int) bool {
+		return m.points[i].hash >= h
+	})
+	for i := 0; i < len(m.points); i++ {
+		point := m.points[(start+i)%len(m.points)]
+		if all || containsBackend(servers, point.backend) {
+			return point.backend
+		}
+	}
+	return nil
+}
+
+// fnv64a之后再用murmur3的finalizer打散，相近的键(如连续的ip)在环上也能均匀分布
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, key)
+	ret := h.Sum64()
+	ret ^= ret >> 33
+	ret *= 0xff51afd7ed558ccd
+	ret ^= ret >> 33
+	ret *= 0xc4ceb9fe1a85ec53
+	ret ^= ret >> 33
+	return ret
+}
+
+// 设置权重为1的后端在一致性hash环上的虚拟节点数，n小于等于0时使用DefaultVirtualNodes
+func (m *LblHttpClient) SetVirtualNodes(n int) {
+	if n <= 0 {
+		n = DefaultVirtualNodes
+	}
+	m.serverListLock.Lock()
+	defer m.serverListLock.Unlock()
+	m.virtualNodes = n
+	m.backendsChanged()
+}
+
+func (m *LblHttpClient) selectBackendByHash(servers []*lblHttpBackend, key string) (*lblHttpBackend, error) {
+	if backend := m.hashRing.get(key, servers, len(servers) == len(m.serverList)); backend != nil {
+		return backend, nil
+	}
+	return servers[0], nil
+}
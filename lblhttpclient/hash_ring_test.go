@@ -0,0 +1,101 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func hashAssignments(lblC *LblHttpClient, keys int) map[string]string {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	ret := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		ip := fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+		backend, _ := lblC.selectBackend(ip, req)
+		ret[ip] = backend.alias
+	}
+	return ret
+}
+
+func TestHashRingDistribution(t *testing.T) {
+	const keys = 100000
+	lblC := NewLoadBalanceClient(MethodIpHash, 10, "hashkey", 0, 0)
+	for i := 0; i < 4; i++ {
+		lblC.AddBackend(fmt.Sprintf("127.0.0.1:%d", 8001+i), fmt.Sprintf("s%d", i), nil)
+	}
+	before := hashAssignments(lblC, keys)
+	counts := make(map[string]int)
+	for _, alias := range before {
+		counts[alias]++
+	}
+	for alias, n := range counts {
+		t.Logf("%s: %d (%.2f%%)", alias, n, float64(n)*100/keys)
+		if math.Abs(float64(n)-keys/4)/(keys/4) > 0.1 {
+			t.Fatalf("unbalanced distribution %v", counts)
+		}
+	}
+
+	// 增加一个后端，只有约1/5的键移动，并且都移动到新的后端
+	lblC.AddBackend("127.0.0.1:8005", "s4", nil)
+	after := hashAssignments(lblC, keys)
+	moved := 0
+	for ip, alias := range after {
+		if alias != before[ip] {
+			moved++
+			if alias != "s4" {
+				t.Fatalf("key %s moved from %s to %s", ip, before[ip], alias)
+			}
+		}
+	}
+	t.Logf("moved after adding a backend: %.2f%%", float64(moved)*100/keys)
+	if ratio := float64(moved) / keys; ratio < 0.15 || ratio > 0.25 {
+		t.Fatalf("%.2f of keys moved after adding a backend", ratio)
+	}
+
+	// 删除一个后端，只有该后端的键移动
+	lblC.RemoveBackend("s1")
+	removed := hashAssignments(lblC, keys)
+	for ip, alias := range removed {
+		if after[ip] != "s1" && alias != after[ip] {
+			t.Fatalf("key %s moved from %s to %s", ip, after[ip], alias)
+		}
+	}
+
+	// 被摘除的后端的键顺时针转移到其他后端，其他键不变
+	lblC.serverMap["s2"].unhealthy = 1
+	lblC.unhealthyCount = 1
+	ejected := hashAssignments(lblC, keys)
+	for ip, alias := range ejected {
+		if alias == "s2" || removed[ip] != "s2" && alias != removed[ip] {
+			t.Fatalf("key %s moved from %s to %s", ip, removed[ip], alias)
+		}
+	}
+}
+
+func TestHashRingLargeWeights(t *testing.T) {
+	a := &lblHttpBackend{alias: "a", weight: 2000}
+	b := &lblHttpBackend{alias: "b", weight: 2000}
+	ring := newHashRing([]*lblHttpBackend{a, b}, DefaultVirtualNodes)
+	if len(ring.points) != 2*DefaultVirtualNodes {
+		t.Fatalf("weights should be divided by their gcd, got %d points", len(ring.points))
+	}
+
+	b.weight = int64(MaxBackendWeight)
+	c := &lblHttpBackend{alias: "c", weight: 1}
+	ring = newHashRing([]*lblHttpBackend{a, b, c}, DefaultVirtualNodes)
+	if len(ring.points) > maxHashRingPoints {
+		t.Fatalf("too many points %d", len(ring.points))
+	}
+	counts := make(map[string]int)
+	for _, p := range ring.points {
+		counts[p.backend.alias]++
+	}
+	if counts["c"] < 1 || math.Abs(float64(counts["b"])/float64(counts["a"])-5) > 0.01 {
+		t.Fatalf("unexpected points per backend %v", counts)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultHealthProbePath     string        = "/"
+	DefaultHealthProbeInterval time.Duration = time.Second * 5
+	DefaultHealthProbeTimeout  time.Duration = time.Second
+	DefaultHealthProbeRise     int           = 2
+	DefaultHealthProbeFall     int           = 3
+
+	// 探测响应最多读取的字节数，读完后连接可以复用
+	maxHealthProbeBodySize int64 = 64 * 1024
+)
+
+var (
+	ErrorNoHealthyServer  = errors.New("no healthy backend server")
+	ErrorBackendUnhealthy = errors.New("backend is unhealthy")
+)
+
+// 主动健康检查(后台探测)的配置，0值的字段使用默认值
+type HealthProbeConfig struct {
+	Scheme         string        // http或https，默认http
+	Path           string        // 探测的路径，默认/
+	Host           string        // 探测请求的Host头，默认为后端的地址
+	ExpectedStatus int           // 期望的状态码，0表示任意2xx
+	Interval       time.Duration // 探测间隔
+	Timeout        time.Duration // 单次探测的超时
+	Rise           int           // 不健康的后端连续成功Rise次后恢复
+	Fall           int           // 健康的后端连续失败Fall次后摘除
+}
+
+func (m HealthProbeConfig) withDefaults() HealthProbeConfig {
+	if m.Scheme == "" {
+		m.Scheme = "http"
+	}
+	if m.Path == "" {
+		m.Path = DefaultHealthProbePath
+	}
+	if m.Interval <= 0 {
+		m.Interval = DefaultHealthProbeInterval
+	}
+	if m.Timeout <= 0 {
+		m.Timeout = DefaultHealthProbeTimeout
+	}
+	if m.Rise <= 0 {
+		m.Rise = DefaultHealthProbeRise
+	}
+	if m.Fall <= 0 {
+		m.Fall = DefaultHealthProbeFall
+	}
+	return m
+}
+
+// 后端健康状态变化的回调，在探测的goroutine中调用
+type HealthStateChange func(alias string, addr string, healthy bool)
+
+// 为所有后端(包括之后添加的)开启主动健康检查，config为nil时关闭。
+// 探测连续失败的后端从所有负载均衡方式的选择中摘除，连续成功后自动恢复
+func (m *LblHttpClient) SetHealthProbe(config *HealthProbeConfig) {
+	m.healthLock.Lock()
+	if config == nil {
+		m.healthProbe = nil
+	} else {
+		probe := config.withDefaults()
+		m.healthProbe = &probe
+	}
+	m.healthLock.Unlock()
+
+	for _, backend := range m.backends() {
+		m.healthLock.Lock()
+		own := backend.probeConfig != nil
+		m.healthLock.Unlock()
+		if !own {
+			m.restartProbe(backend)
+		}
+	}
+}
+
+// 为指定的后端单独设置主动健康检查，覆盖SetHealthProbe的配置，config为nil时恢复使用SetHealthProbe的配置
+func (m *LblHttpClient) SetBackendHealthProbe(alias string, config *HealthProbeConfig) error {
+	m.serverListLock.RLock()
+	backend, ok := m.serverMap[alias]
+	m.serverListLock.RUnlock()
+	if !ok {
+		return ErrorAliasNotExist
+	}
+	m.healthLock.Lock()
+	if config == nil {
+		backend.probeConfig = nil
+	} else {
+		probe := config.withDefaults()
+		backend.probeConfig = &probe
+	}
+	m.healthLock.Unlock()
+	m.restartProbe(backend)
+	return nil
+}
+
+// 设置后端健康状态变化的回调
+func (m *LblHttpClient) OnHealthStateChange(callback HealthStateChange) {
+	m.healthLock.Lock()
+	defer m.healthLock.Unlock()
+	m.onHealthChange = callback
+}
+
+// 后端是否健康，没有开启主动健康检查的后端总是健康的
+func (m *LblHttpClient) BackendHealthy(alias string) (bool, error) {
+	m.serverListLock.RLock()
+	defer m.serverListLock.RUnlock()
+	backend, ok := m.serverMap[alias]
+	if !ok {
+		return false, ErrorAliasNotExist
+	}
+	return backend.Healthy(), nil
+}
+
+// 停止所有后台探测
+func (m *LblHttpClient) Close() {
+	m.healthLock.Lock()
+	m.healthProbe = nil
+	m.healthLock.Unlock()
+	for _, backend := range m.backends() {
+		m.stopProbe(backend)
+	}
+}
+
+func (m *LblHttpClient) backends() []*lblHttpBackend {
+	m.serverListLock.RLock()
+	defer m.serverListLock.RUnlock()
+	return append([]*lblHttpBackend(nil), m.serverList...)
+}
+
+func (m *lblHttpBackend) Healthy() bool {
+	return atomic.LoadInt32(&m.unhealthy) == 0
+}
+
+// 按当前配置重新开始后端的探测，没有配置时停止探测并恢复为健康
+func (m *LblHttpClient) restartProbe(backend *lblHttpBackend) {
+	m.healthLock.Lock()
+	if backend.probeStop != nil {
+		close(backend.probeStop)
+		backend.probeStop = nil
+	}
+	config := backend.probeConfig
+	if config == nil {
+		config = m.healthProbe
+	}
+	if config == nil {
+		m.healthLock.Unlock()
+		m.setBackendHealthy(backend, true, nil)
+		return
+	}
+	stop := make(chan struct{})
+	backend.probeStop = stop
+	m.healthLock.Unlock()
+	go m.runProbe(backend, *config, stop)
+}
+
+// 停止后端的探测，后端恢复为健康
+func (m *LblHttpClient) stopProbe(backend *lblHttpBackend) {
+	m.healthLock.Lock()
+	if backend.probeStop != nil {
+		close(backend.probeStop)
+		backend.probeStop = nil
+	}
+	m.healthLock.Unlock()
+	m.setBackendHealthy(backend, true, nil)
+}
+
+func (m *LblHttpClient) runProbe(backend *lblHttpBackend, config HealthProbeConfig, stop chan struct{}) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	successes, failures := 0, 0
+	for {
+		if backend.probe(config) {
+			successes, failures = successes+1, 0
+			if successes >= config.Rise {
+				m.setBackendHealthy(backend, true, stop)
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if failures >= config.Fall {
+				m.setBackendHealthy(backend, false, stop)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 设置后端的健康状态，stop不为nil时只有探测没有被停止才设置
+func (m *LblHttpClient) setBackendHealthy(backend *lblHttpBackend, healthy bool, stop chan struct{}) {
+	m.healthLock.Lock()
+	if stop != nil {
+		select {
+		case <-stop:
+			m.healthLock.Unlock()
+			return
+		default:
+		}
+	}
+	if backend.Healthy() == healthy {
+		m.healthLock.Unlock()
+		return
+	}
+	if healthy {
+		atomic.StoreInt32(&backend.unhealthy, 0)
+		atomic.AddInt64(&m.unhealthyCount, -1)
+	} else {
+		atomic.StoreInt32(&backend.unhealthy, 1)
+		atomic.AddInt64(&m.unhealthyCount, 1)
+	}
+	callback := m.onHealthChange
+	m.healthLock.Unlock()
+	if callback != nil {
+		callback(backend.alias, backend.addr, healthy)
+	}
+}
+
+// 发送一次探测请求，返回是否成功
+func (m *lblHttpBackend) probe(config HealthProbeConfig) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Scheme+"://"+m.addr+config.Path, nil)
+	if err != nil {
+		return false
+	}
+	if config.Host != "" {
+		req.Host = config.Host
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxHealthProbeBodySize))
+	resp.Body.Close()
+	if config.ExpectedStatus == 0 {
+		return resp.StatusCode >= 200 && resp.StatusCode < 300
+	}
+	return resp.StatusCode == config.ExpectedStatus
+}
+
+// 参与选择的后端: 健康的、不在exclude中的后端
+func (m *LblHttpClient) availableBackends(exclude []*lblHttpBackend) []*lblHttpBackend {
+	if atomic.LoadInt64(&m.unhealthyCount) == 0 && len(exclude) == 0 {
+		return m.serverList
+	}
+	ret := make([]*lblHttpBackend, 0, len(m.serverList))
+	for _, backend := range m.serverList {
+		if backend.Healthy() && !containsBackend(exclude, backend) {
+			ret = append(ret, backend)
+		}
+	}
+	return ret
+}
+
+func containsBackend(list []*lblHttpBackend, backend *lblHttpBackend) bool {
+	for _, v := range list {
+		if v == backend {
+			return true
+		}
+	}
+	return false
+}
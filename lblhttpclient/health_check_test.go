@@ -0,0 +1,120 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestBackend(name string, healthy *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && atomic.LoadInt32(healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(name))
+	}))
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 3)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	healthy1, healthy2 := int32(1), int32(1)
+	s1 := newTestBackend("s1", &healthy1)
+	defer s1.Close()
+	s2 := newTestBackend("s2", &healthy2)
+	defer s2.Close()
+
+	for _, method := range []LoadBalanceMethod{MethodRoundrobin, MethodRandom, MethodMinPending, MethodIpHash, MethodUrlParam} {
+		lblC := NewLoadBalanceClient(method, 10, "hashkey", 0, 0)
+		var changesLock sync.Mutex
+		var changes []string
+		lblC.OnHealthStateChange(func(alias string, addr string, healthy bool) {
+			changesLock.Lock()
+			defer changesLock.Unlock()
+			if healthy {
+				changes = append(changes, alias+" up")
+			} else {
+				changes = append(changes, alias+" down")
+			}
+		})
+		lblC.AddBackend(strings.TrimPrefix(s1.URL, "http://"), "s1", nil)
+		lblC.AddBackend(strings.TrimPrefix(s2.URL, "http://"), "s2", nil)
+		lblC.SetHealthProbe(&HealthProbeConfig{Path: "/health", Interval: time.Millisecond * 10, Rise: 2, Fall: 2})
+
+		atomic.StoreInt32(&healthy1, 0)
+		waitFor(t, func() bool {
+			ok, _ := lblC.BackendHealthy("s1")
+			return !ok
+		})
+		for i := 0; i < 20; i++ {
+			req, _ := http.NewRequest("GET", "http://localhost/?hashkey="+string(rune('a'+i)), nil)
+			backend, err := lblC.selectBackend("10.0.0."+string(rune('0'+i%10)), req)
+			if err != nil || backend.alias != "s2" {
+				t.Fatalf("method %d: unhealthy backend selected, %v", method, err)
+			}
+		}
+
+		atomic.StoreInt32(&healthy2, 0)
+		waitFor(t, func() bool {
+			ok, _ := lblC.BackendHealthy("s2")
+			return !ok
+		})
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		if _, err := lblC.DoRequest("127.0.0.1", req); err != ErrorNoHealthyServer {
+			t.Fatalf("expect ErrorNoHealthyServer, got %v", err)
+		}
+
+		atomic.StoreInt32(&healthy1, 1)
+		atomic.StoreInt32(&healthy2, 1)
+		waitFor(t, func() bool {
+			ok1, _ := lblC.BackendHealthy("s1")
+			ok2, _ := lblC.BackendHealthy("s2")
+			return ok1 && ok2
+		})
+		lblC.Close()
+		changesLock.Lock()
+		if strings.Join(changes, ",") != "s1 down,s2 down,s1 up,s2 up" && strings.Join(changes, ",") != "s1 down,s2 down,s2 up,s1 up" {
+			t.Fatalf("unexpected state changes %v", changes)
+		}
+		changesLock.Unlock()
+	}
+}
+
+func TestBackendHealthProbe(t *testing.T) {
+	healthy := int32(1)
+	s := newTestBackend("s", &healthy)
+	defer s.Close()
+	lblC := NewLoadBalanceClient(MethodRoundrobin, 10, "hashkey", 0, 0)
+	defer lblC.Close()
+	lblC.AddBackend(strings.TrimPrefix(s.URL, "http://"), "s", nil)
+	if err := lblC.SetBackendHealthProbe("x", &HealthProbeConfig{}); err != ErrorAliasNotExist {
+		t.Fatalf("expect ErrorAliasNotExist, got %v", err)
+	}
+	lblC.SetBackendHealthProbe("s", &HealthProbeConfig{Path: "/health", ExpectedStatus: http.StatusServiceUnavailable, Interval: time.Millisecond * 10, Fall: 1})
+	waitFor(t, func() bool {
+		ok, _ := lblC.BackendHealthy("s")
+		return !ok
+	})
+	// 删除后端停止探测
+	lblC.RemoveBackend("s")
+	if n := atomic.LoadInt64(&lblC.unhealthyCount); n != 0 {
+		t.Fatalf("unhealthy count = %d", n)
+	}
+}
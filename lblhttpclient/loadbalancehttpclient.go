@@ -5,9 +5,8 @@
 package lblhttpclient
 
 import (
+	"context"
 	"errors"
-	"hash/fnv"
-	"io"
 	"math/rand"
 	"net"
 	"net/http"
@@ -28,6 +27,8 @@ const (
 	MethodIpHash
 	MethodUrlParam
 	MethodJsonExp // very powerful
+	MethodWeightedRoundrobin
+	MethodP2CEwma // 随机选择两个后端，取延迟(指数加权移动平均) * (pending + 1) / 权重较低的一个
 )
 
 const (
@@ -79,12 +80,21 @@ func doInit() {
 }
 
 type lblHttpBackend struct {
+	// 原子操作的int64放在最前面，保证在32位平台上8字节对齐
 	pendingRequests      int64
-	healthCheck          HealthCheck
 	healthCheckFailCount int64
+	weight               int64
+	currentWeight        int64 // 平滑加权轮询的当前权重
+	healthCheck          HealthCheck
 	httpClient           *http.Client
 	addr                 string // ip:port
 	alias                string
+	latency              ewma
+
+	// 主动健康检查
+	unhealthy   int32
+	probeConfig *HealthProbeConfig // 单独设置的探测配置
+	probeStop   chan struct{}
 }
 
 func (m *lblHttpBackend) PendingRequests() int64 {
@@ -102,6 +112,7 @@ func newLblHttpBackend(addr string, alias string, maxIdleConns int, connTimeout,
 		healthCheck: healthCheck,
 		addr:        addr,
 		alias:       alias,
+		weight:      int64(DefaultBackendWeight),
 		httpClient: &http.Client{
 			Transport: &http.Transport{
 				Proxy: http.ProxyFromEnvironment,
@@ -124,13 +135,20 @@ func newLblHttpBackend(addr string, alias string, maxIdleConns int, connTimeout,
 }
 
 type LblHttpClient struct {
+	// 原子操作的int64放在最前面，保证在32位平台上8字节对齐
+	roundrobinIndex   int64
+	ewmaDecay         int64
+	unhealthyCount    int64
 	randObj           *rand.Rand
 	serverListLock    sync.RWMutex
 	serverList        []*lblHttpBackend
 	serverMap         map[string]*lblHttpBackend // key is backend's alias
 	method            LoadBalanceMethod
-	roundrobinIndex   int64
 	methodUrlParamKey string
+	wrrLock           sync.Mutex
+	weighted          bool // 后端的权重是否不同
+	virtualNodes      int
+	hashRing          *hashRing
 
 	// method MethodJsonExp
 	jsonExpLock   sync.RWMutex
@@ -139,6 +157,14 @@ type LblHttpClient struct {
 	maxIdleConnectionsPerServer int
 	connectTimeout              time.Duration
 	waitResponseTimeout         time.Duration
+
+	retryLock   sync.RWMutex
+	retryPolicy *RetryPolicy
+
+	// 主动健康检查
+	healthLock     sync.Mutex
+	healthProbe    *HealthProbeConfig
+	onHealthChange HealthStateChange
 }
 
 func NewLoadBalanceClient(method LoadBalanceMethod, maxIdleConnectionsPerServer int, methodUrlParamKey string, connTimeout, waitResponseTimeout time.Duration) *LblHttpClient {
@@ -152,7 +178,7 @@ func NewLoadBalanceClient(method LoadBalanceMethod, maxIdleConnectionsPerServer
 	}
 
 	ret := &LblHttpClient{
-		randObj:                     rand.New(rand.NewSource(time.Now().UnixNano())),
+		randObj:                     rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano()).(rand.Source64)}),
 		method:                      method,
 		methodUrlParamKey:           methodUrlParamKey,
 		maxIdleConnectionsPerServer: maxIdleConnectionsPerServer,
@@ -160,6 +186,9 @@ func NewLoadBalanceClient(method LoadBalanceMethod, maxIdleConnectionsPerServer
 		waitResponseTimeout:         waitResponseTimeout,
 		serverList:                  make([]*lblHttpBackend, 0),
 		serverMap:                   make(map[string]*lblHttpBackend),
+		virtualNodes:                DefaultVirtualNodes,
+		hashRing:                    &hashRing{},
+		ewmaDecay:                   int64(DefaultEwmaDecay),
 	}
 
 	return ret
@@ -186,17 +215,19 @@ func (m *LblHttpClient) AddBackend(addr string, alias string, healthCheck Health
 	}
 
 	m.serverListLock.Lock()
-	defer m.serverListLock.Unlock()
 	m.serverList = append(m.serverList, backend)
 	m.serverMap[alias] = backend
+	m.backendsChanged()
+	m.serverListLock.Unlock()
+	m.restartProbe(backend)
 
 	return nil
 }
 
 func (m *LblHttpClient) RemoveBackend(alias string) error {
 	m.serverListLock.Lock()
-	defer m.serverListLock.Unlock()
-	if _, ok := m.serverMap[alias]; ok {
+	backend, ok := m.serverMap[alias]
+	if ok {
 		delete(m.serverMap, alias)
 		for i, v := range m.serverList {
 			if v.alias == alias {
@@ -207,22 +238,34 @@ func (m *LblHttpClient) RemoveBackend(alias string) error {
 				m.serverList = newList
 			}
 		}
-		return nil
-	} else {
+		m.backendsChanged()
+	}
+	m.serverListLock.Unlock()
+	if !ok {
 		return ErrorAliasNotExist
 	}
+	m.stopProbe(backend)
+	return nil
 }
 
+// 发送请求，设置了重试策略时失败的请求在其他后端上重试，见SetRetryPolicy
 func (m *LblHttpClient) DoRequest(clientIp string, request *http.Request) (*http.Response, error) {
-	backend, err := m.selectBackend(clientIp, request)
-	if err != nil {
-		return nil, err
-	}
+	ret, _, err := m.DoRequestWithAttempts(clientIp, request)
+	return ret, err
+}
+
+// 将请求发送到backend
+func (m *LblHttpClient) send(backend *lblHttpBackend, request *http.Request) (*http.Response, error) {
 	request.URL.Host = backend.addr
 	request.Host = backend.addr
 	atomic.AddInt64(&backend.pendingRequests, 1)
+	start := time.Now()
 	ret, err := backend.httpClient.Do(request)
 	atomic.AddInt64(&backend.pendingRequests, -1)
+	// 调用者取消的请求不代表后端的延迟
+	if !errors.Is(err, context.Canceled) {
+		m.observeLatency(backend, time.Since(start), err)
+	}
 	if !backend.healthCheck(request, ret, err) {
 		atomic.AddInt64(&backend.healthCheckFailCount, 1)
 	} else {
@@ -245,58 +288,54 @@ func (m *LblHttpClient) getJsonExp() *jsonexp.JsonExpGroup {
 	}
 }
 
-func (m *LblHttpClient) selectBackendRoundrobin() (*lblHttpBackend, error) {
+func (m *LblHttpClient) selectBackendRoundrobin(servers []*lblHttpBackend) (*lblHttpBackend, error) {
 	idx := atomic.LoadInt64(&m.roundrobinIndex)
 	atomic.AddInt64(&m.roundrobinIndex, 1)
-	idx %= int64(len(m.serverList))
-	ret := m.serverList[idx]
+	idx %= int64(len(servers))
+	ret := servers[idx]
 	return ret, nil
 }
 
-func (m *LblHttpClient) selectBackendRandom() (*lblHttpBackend, error) {
-	idx := m.randObj.Intn(len(m.serverList))
-	ret := m.serverList[idx]
+func (m *LblHttpClient) selectBackendRandom(servers []*lblHttpBackend) (*lblHttpBackend, error) {
+	if m.weighted {
+		return m.selectBackendWeightedRandom(servers)
+	}
+	idx := m.randObj.Intn(len(servers))
+	ret := servers[idx]
 	return ret, nil
 }
 
-func (m *LblHttpClient) selectBackendMinPending() (*lblHttpBackend, error) {
+func (m *LblHttpClient) selectBackendMinPending(servers []*lblHttpBackend) (*lblHttpBackend, error) {
 	idx := atomic.AddInt64(&m.roundrobinIndex, 1) - 1
-	idx %= int64(len(m.serverList))
+	idx %= int64(len(servers))
 	minIdx := idx
-	minPending := m.serverList[minIdx].PendingRequests()
+	minPending, minWeight := servers[minIdx].PendingRequests(), servers[minIdx].Weight()
 	if minPending > 0 {
-		for i := 0; i < len(m.serverList); i++ {
+		for i := 0; i < len(servers); i++ {
 			idx++
-			if idx >= int64(len(m.serverList)) {
+			if idx >= int64(len(servers)) {
 				idx = 0
 			}
-			pr := m.serverList[idx].PendingRequests()
-			if pr < minPending {
-				minPending = pr
+			// 按pending/weight比较
+			pr, weight := servers[idx].PendingRequests(), servers[idx].Weight()
+			if pr*minWeight < minPending*weight {
+				minPending, minWeight = pr, weight
 				minIdx = idx
 			}
 		}
 	}
-	return m.serverList[minIdx], nil
+	return servers[minIdx], nil
 }
 
-func (m *LblHttpClient) selectBackendIpHash(clientIp string) (*lblHttpBackend, error) {
-	fnv32 := fnv.New32()
-	io.WriteString(fnv32, clientIp)
-	idx := fnv32.Sum32()
-	idx = idx % uint32(len(m.serverList))
-	return m.serverList[int(idx)], nil
+func (m *LblHttpClient) selectBackendIpHash(servers []*lblHttpBackend, clientIp string) (*lblHttpBackend, error) {
+	return m.selectBackendByHash(servers, clientIp)
 }
 
-func (m *LblHttpClient) selectBackendUrlParam(paramValue string) (*lblHttpBackend, error) {
-	fnv32 := fnv.New32()
-	io.WriteString(fnv32, paramValue)
-	idx := fnv32.Sum32()
-	idx = idx % uint32(len(m.serverList))
-	return m.serverList[int(idx)], nil
+func (m *LblHttpClient) selectBackendUrlParam(servers []*lblHttpBackend, paramValue string) (*lblHttpBackend, error) {
+	return m.selectBackendByHash(servers, paramValue)
 }
 
-func (m *LblHttpClient) selectBackendJsonExp(request *http.Request) (*lblHttpBackend, error) {
+func (m *LblHttpClient) selectBackendJsonExp(request *http.Request, exclude []*lblHttpBackend) (*lblHttpBackend, error) {
 	jsonExp := m.getJsonExp()
 	if jsonExp == nil {
 		return nil, ErrorJsonExpNotFound
@@ -314,34 +353,52 @@ func (m *LblHttpClient) selectBackendJsonExp(request *http.Request) (*lblHttpBac
 	}
 	targetAliasStr, _ := jsonexp.GetStringValue(targetAlias)
 	if backend, ok := m.serverMap[targetAliasStr]; ok {
+		if !backend.Healthy() {
+			return nil, ErrorBackendUnhealthy
+		}
+		if containsBackend(exclude, backend) {
+			return nil, ErrorNoUntriedServer
+		}
 		return backend, nil
 	}
 	return nil, ErrorJsonExpNotFound
 }
 
-func (m *LblHttpClient) selectBackend(clientIp string, request *http.Request) (*lblHttpBackend, error) {
+// 选择后端，exclude为已经尝试过的后端
+func (m *LblHttpClient) selectBackend(clientIp string, request *http.Request, exclude ...*lblHttpBackend) (*lblHttpBackend, error) {
 	m.serverListLock.RLock()
 	defer m.serverListLock.RUnlock()
 
 	if len(m.serverList) == 0 {
 		return nil, ErrorNoServerDefined
 	}
+	servers := m.availableBackends(exclude)
+	if len(servers) == 0 {
+		if len(exclude) > 0 {
+			return nil, ErrorNoUntriedServer
+		}
+		return nil, ErrorNoHealthyServer
+	}
 	switch m.method {
 	case MethodRoundrobin:
-		return m.selectBackendRoundrobin()
+		return m.selectBackendRoundrobin(servers)
 	case MethodRandom:
-		return m.selectBackendRandom()
+		return m.selectBackendRandom(servers)
 	case MethodMinPending:
-		return m.selectBackendMinPending()
+		return m.selectBackendMinPending(servers)
 	case MethodIpHash:
-		return m.selectBackendIpHash(clientIp)
+		return m.selectBackendIpHash(servers, clientIp)
 	case MethodUrlParam:
 		u := request.URL
 		paramValue := u.Query().Get(m.methodUrlParamKey)
-		return m.selectBackendUrlParam(paramValue)
+		return m.selectBackendUrlParam(servers, paramValue)
 	case MethodJsonExp:
-		return m.selectBackendJsonExp(request)
+		return m.selectBackendJsonExp(request, exclude)
+	case MethodWeightedRoundrobin:
+		return m.selectBackendWeightedRoundrobin(servers)
+	case MethodP2CEwma:
+		return m.selectBackendP2C(servers)
 	default:
-		return m.selectBackendMinPending()
+		return m.selectBackendMinPending(servers)
 	}
 }
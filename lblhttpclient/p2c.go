@@ -0,0 +1,119 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 延迟的指数加权移动平均的默认衰减时间常数，越大越平滑
+	DefaultEwmaDecay time.Duration = time.Second * 10
+	// 失败的请求按至少这么长的延迟记录，避免快速失败的后端吸引流量
+	ewmaErrorPenalty time.Duration = time.Second
+)
+
+// 按时间衰减的指数加权移动平均，值为纳秒
+type ewma struct {
+	lock  sync.Mutex
+	value float64
+	stamp time.Time
+}
+
+// 记录一个样本。peak为true时样本大于当前值则直接取样本，延迟变大时立即反应，变小时逐渐恢复
+func (m *ewma) observe(sample float64, now time.Time, decay time.Duration, peak bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stamp.IsZero() || peak && sample > m.value {
+		m.value = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(m.stamp)) / float64(decay))
+		m.value = m.value*w + sample*(1-w)
+	}
+	m.stamp = now
+}
+
+// 当前值，没有样本时返回false。decay大于0时值随距上次样本的时间衰减，
+// 一段时间没有被选择的后端的延迟逐渐降低，从而重新获得流量以更新延迟
+func (m *ewma) get(now time.Time, decay time.Duration) (float64, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stamp.IsZero() {
+		return 0, false
+	}
+	if decay <= 0 || !now.After(m.stamp) {
+		return m.value, true
+	}
+	return m.value * math.Exp(-float64(now.Sub(m.stamp))/float64(decay)), true
+}
+
+// 设置延迟的指数加权移动平均的衰减时间常数，d小于等于0时使用DefaultEwmaDecay
+func (m *LblHttpClient) SetEwmaDecay(d time.Duration) {
+	if d <= 0 {
+		d = DefaultEwmaDecay
+	}
+	atomic.StoreInt64(&m.ewmaDecay, int64(d))
+}
+
+func (m *LblHttpClient) getEwmaDecay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.ewmaDecay))
+}
+
+// 记录后端的响应延迟(到收到响应头为止)
+func (m *LblHttpClient) observeLatency(backend *lblHttpBackend, latency time.Duration, err error) {
+	if err != nil && latency < ewmaErrorPenalty {
+		latency = ewmaErrorPenalty
+	}
+	backend.latency.observe(float64(latency), time.Now(), m.getEwmaDecay(), true)
+}
+
+// 后端的负载: 延迟 * (pending + 1) / 权重。
+// 没有延迟记录的新后端没有pending时负载为0，优先被选择以获得延迟；有pending时负载最大，
+// 等到第一个响应之后才参与比较，避免新后端在获得延迟之前涌入大量请求
+func (m *LblHttpClient) backendScore(backend *lblHttpBackend, now time.Time) float64 {
+	pending := backend.PendingRequests()
+	latency, ok := backend.latency.get(now, m.getEwmaDecay())
+	if !ok {
+		if pending > 0 {
+			return math.MaxFloat64
+		}
+		return 0
+	}
+	return latency * float64(pending+1) / float64(backend.Weight())
+}
+
+// 后端当前的延迟(指数加权移动平均)，没有延迟记录时为0
+func (m *LblHttpClient) BackendLatency(alias string) (time.Duration, error) {
+	m.serverListLock.RLock()
+	defer m.serverListLock.RUnlock()
+	backend, ok := m.serverMap[alias]
+	if !ok {
+		return 0, ErrorAliasNotExist
+	}
+	ret, _ := backend.latency.get(time.Now(), m.getEwmaDecay())
+	return time.Duration(ret), nil
+}
+
+// power of two choices: 随机选择两个后端，取负载较低的一个
+func (m *LblHttpClient) selectBackendP2C(servers []*lblHttpBackend) (*lblHttpBackend, error) {
+	if len(servers) == 1 {
+		return servers[0], nil
+	}
+	i := rand.Intn(len(servers))
+	j := rand.Intn(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	now := time.Now()
+	a, b := servers[i], servers[j]
+	if m.backendScore(b, now) < m.backendScore(a, now) {
+		return b, nil
+	}
+	return a, nil
+}
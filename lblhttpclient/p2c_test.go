@@ -0,0 +1,94 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestP2CEwma(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 200)
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	lblC := NewLoadBalanceClient(MethodP2CEwma, 10, "hashkey", 0, 0)
+	lblC.SetEwmaDecay(time.Second)
+	lblC.AddBackend(strings.TrimPrefix(slow.URL, "http://"), "slow", nil)
+	lblC.AddBackend(strings.TrimPrefix(fast.URL, "http://"), "fast", nil)
+	counts := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		resp, attempts, err := lblC.DoRequestWithAttempts("127.0.0.1", req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		counts[attempts[0].Backend]++
+	}
+	if counts["slow"] > 5 {
+		t.Fatalf("slow backend selected too often %v", counts)
+	}
+	if _, err := lblC.BackendLatency("x"); err != ErrorAliasNotExist {
+		t.Fatalf("expect ErrorAliasNotExist, got %v", err)
+	}
+
+	// 没有延迟记录的新后端先获得一个请求，在第一个响应之前不再被选择
+	lblC.AddBackend("127.0.0.1:8001", "new", nil)
+	newBackend := lblC.serverMap["new"]
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	if backend, _ := lblC.selectBackendP2C([]*lblHttpBackend{lblC.serverMap["fast"], newBackend}); backend != newBackend {
+		t.Fatalf("expect new backend to be selected")
+	}
+	newBackend.pendingRequests = 1
+	if backend, _ := lblC.selectBackendP2C([]*lblHttpBackend{lblC.serverMap["slow"], newBackend}); backend == newBackend {
+		t.Fatalf("new backend with pending requests should not be selected")
+	}
+
+	// 延迟相同时选择pending较少的后端
+	lblC = NewLoadBalanceClient(MethodP2CEwma, 10, "hashkey", 0, 0)
+	lblC.AddBackend("127.0.0.1:8001", "a", nil)
+	lblC.AddBackend("127.0.0.1:8002", "b", nil)
+	lblC.serverMap["a"].latency.observe(float64(time.Millisecond), time.Now(), DefaultEwmaDecay, true)
+	lblC.serverMap["b"].latency.observe(float64(time.Millisecond), time.Now(), DefaultEwmaDecay, true)
+	lblC.serverMap["a"].pendingRequests = 3
+	for i := 0; i < 10; i++ {
+		if backend, _ := lblC.selectBackend("", req); backend.alias != "b" {
+			t.Fatalf("expect backend with fewer pending requests")
+		}
+	}
+}
+
+func TestEwma(t *testing.T) {
+	var e ewma
+	now := time.Now()
+	decay := DefaultEwmaDecay
+	if _, ok := e.get(now, decay); ok {
+		t.Fatalf("expect no value")
+	}
+	e.observe(100, now, decay, true)
+	e.observe(50, now.Add(decay), decay, true)
+	if v, _ := e.get(now.Add(decay), 0); v < 60 || v > 70 {
+		t.Fatalf("value = %v", v)
+	}
+	e.observe(200, now.Add(decay), decay, true)
+	if v, _ := e.get(now.Add(decay), 0); v != 200 {
+		t.Fatalf("peak value = %v", v)
+	}
+	if v, _ := e.get(now.Add(decay*2), decay); v > 80 {
+		t.Fatalf("decayed value = %v", v)
+	}
+}
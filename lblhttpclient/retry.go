@@ -0,0 +1,221 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultMaxBufferedBody int64 = 1024 * 1024
+)
+
+var (
+	ErrorNoUntriedServer = errors.New("no untried backend server")
+)
+
+// 重试策略，失败的请求在其他没有尝试过的后端上重试
+type RetryPolicy struct {
+	MaxAttempts int // 最多尝试的次数(包括第一次)，小于等于1时不重试
+
+	// 需要重试的状态码，例如502、503、504，最后一次尝试的响应原样返回
+	RetryOnStatus []int
+
+	// 判断错误是否需要重试，为nil时所有错误都重试。请求本身的context被取消或超时时不重试
+	RetryOnError func(err error) bool
+
+	// 默认只重试幂等的方法(GET、HEAD、OPTIONS、TRACE、PUT、DELETE)，以及带有Idempotency-Key头的请求
+	RetryNonIdempotent bool
+
+	// 每次尝试的超时，0表示不限制
+	PerTryTimeout time.Duration
+
+	// 没有GetBody的请求体最多缓冲的字节数，超过时不重试，默认为DefaultMaxBufferedBody
+	MaxBufferedBody int64
+}
+
+// 一次尝试
+type Attempt struct {
+	Backend    string        // 后端的alias
+	Addr       string        // 后端的地址
+	StatusCode int           // 出错时为0
+	Err        error         // 传输错误
+	Duration   time.Duration // 到收到响应头为止的耗时
+}
+
+// 设置重试策略，policy为nil时不重试
+func (m *LblHttpClient) SetRetryPolicy(policy *RetryPolicy) {
+	m.retryLock.Lock()
+	defer m.retryLock.Unlock()
+	if policy == nil {
+		m.retryPolicy = nil
+		return
+	}
+	p := *policy
+	if p.MaxBufferedBody <= 0 {
+		p.MaxBufferedBody = DefaultMaxBufferedBody
+	}
+	m.retryPolicy = &p
+}
+
+func (m *LblHttpClient) getRetryPolicy() *RetryPolicy {
+	m.retryLock.RLock()
+	defer m.retryLock.RUnlock()
+	return m.retryPolicy
+}
+
+// 与DoRequest相同，同时返回每次尝试的记录
+func (m *LblHttpClient) DoRequestWithAttempts(clientIp string, request *http.Request) (*http.Response, []*Attempt, error) {
+	policy := m.getRetryPolicy()
+	if policy == nil || (policy.MaxAttempts <= 1 && policy.PerTryTimeout <= 0) {
+		backend, err := m.selectBackend(clientIp, request)
+		if err != nil {
+			return nil, nil, err
+		}
+		start := time.Now()
+		ret, err := m.send(backend, request)
+		return ret, []*Attempt{newAttempt(backend, ret, err, start)}, err
+	}
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 1 || !policy.RetryNonIdempotent && !isIdempotent(request) {
+		maxAttempts = 1
+	}
+	var getBody func() (io.ReadCloser, error)
+	var err error
+	if maxAttempts > 1 {
+		if getBody, err = replayableBody(request, policy.MaxBufferedBody); err != nil {
+			return nil, nil, err
+		}
+		if getBody == nil {
+			maxAttempts = 1
+		}
+	}
+
+	var attempts []*Attempt
+	var tried []*lblHttpBackend
+	var ret *http.Response
+	for i := 0; i < maxAttempts; i++ {
+		backend, selectErr := m.selectBackend(clientIp, request, tried...)
+		if selectErr != nil {
+			if i == 0 {
+				return nil, nil, selectErr
+			}
+			break
+		}
+		tried = append(tried, backend)
+		if ret != nil {
+			// 丢弃上一次需要重试的响应
+			io.Copy(ioutil.Discard, io.LimitReader(ret.Body, maxHealthProbeBodySize))
+			ret.Body.Close()
+		}
+
+		ctx, cancel := request.Context(), context.CancelFunc(func() {})
+		if policy.PerTryTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, policy.PerTryTimeout)
+		}
+		req := request.Clone(ctx)
+		if i > 0 || maxAttempts > 1 && request.GetBody == nil {
+			if req.Body, err = getBody(); err != nil {
+				cancel()
+				return nil, attempts, err
+			}
+		}
+		start := time.Now()
+		ret, err = m.send(backend, req)
+		attempts = append(attempts, newAttempt(backend, ret, err, start))
+		if err != nil {
+			cancel()
+			ret = nil
+			if request.Context().Err() != nil || policy.RetryOnError != nil && !policy.RetryOnError(err) {
+				break
+			}
+			continue
+		}
+		ret.Body = &cancelOnClose{ReadCloser: ret.Body, cancel: cancel}
+		if !containsStatus(policy.RetryOnStatus, ret.StatusCode) {
+			break
+		}
+	}
+	return ret, attempts, err
+}
+
+func newAttempt(backend *lblHttpBackend, resp *http.Response, err error, start time.Time) *Attempt {
+	ret := &Attempt{Backend: backend.alias, Addr: backend.addr, Err: err, Duration: time.Since(start)}
+	if resp != nil {
+		ret.StatusCode = resp.StatusCode
+	}
+	return ret
+}
+
+func isIdempotent(request *http.Request) bool {
+	switch request.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := request.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = request.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+func containsStatus(list []int, status int) bool {
+	for _, v := range list {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回可以重复获取请求体的函数，请求没有请求体时返回http.NoBody。
+// 请求体只能读取一次且超过maxBuffered时返回nil，此时request.Body被替换为等价的reader，请求只能发送一次
+func replayableBody(request *http.Request, maxBuffered int64) (func() (io.ReadCloser, error), error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return func() (io.ReadCloser, error) { return http.NoBody, nil }, nil
+	}
+	if request.GetBody != nil {
+		return request.GetBody, nil
+	}
+	body := request.Body
+	buf, err := ioutil.ReadAll(io.LimitReader(body, maxBuffered+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > maxBuffered {
+		request.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(buf), body), closer: body}
+		return nil, nil
+	}
+	body.Close()
+	return func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(buf)), nil }, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	return m.closer.Close()
+}
+
+// 响应体关闭时取消单次尝试的context
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (m *cancelOnClose) Close() error {
+	err := m.ReadCloser.Close()
+	m.cancel()
+	return err
+}
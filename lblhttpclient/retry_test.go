@@ -0,0 +1,107 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Millisecond * 500):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append([]byte("good:"), body...))
+	}))
+	defer good.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadAddr := strings.TrimPrefix(dead.URL, "http://")
+	dead.Close()
+
+	lblC := NewLoadBalanceClient(MethodRoundrobin, 10, "hashkey", 0, 0)
+	lblC.AddBackend(deadAddr, "dead", nil)
+	lblC.AddBackend(strings.TrimPrefix(slow.URL, "http://"), "slow", nil)
+	lblC.AddBackend(strings.TrimPrefix(bad.URL, "http://"), "bad", nil)
+	lblC.AddBackend(strings.TrimPrefix(good.URL, "http://"), "good", nil)
+	lblC.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:     4,
+		RetryOnStatus:   []int{http.StatusServiceUnavailable},
+		PerTryTimeout:   time.Millisecond * 100,
+		MaxBufferedBody: 16,
+	})
+
+	req, _ := http.NewRequest("PUT", "http://localhost/", ioutil.NopCloser(strings.NewReader("data")))
+	resp, attempts, err := lblC.DoRequestWithAttempts("127.0.0.1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "good:data" {
+		t.Fatalf("body = %s", body)
+	}
+	tried := make(map[string]*Attempt)
+	for _, a := range attempts {
+		tried[a.Backend] = a
+	}
+	if len(attempts) != 4 || len(tried) != 4 || attempts[3].Backend != "good" ||
+		tried["dead"].Err == nil || tried["slow"].Err == nil || tried["bad"].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected attempts %v", tried)
+	}
+
+	// 非幂等的方法不重试
+	req, _ = http.NewRequest("POST", "http://localhost/", strings.NewReader("data"))
+	if _, attempts, _ = lblC.DoRequestWithAttempts("127.0.0.1", req); len(attempts) != 1 {
+		t.Fatalf("POST should not be retried, attempts: %d", len(attempts))
+	}
+	req.Header.Set("Idempotency-Key", "1")
+	if _, attempts, _ = lblC.DoRequestWithAttempts("127.0.0.1", req); len(attempts) < 2 {
+		t.Fatalf("POST with Idempotency-Key should be retried, attempts: %d", len(attempts))
+	}
+
+	// 超过缓冲限制的请求体不重试，请求体完整发送
+	for {
+		req, _ = http.NewRequest("PUT", "http://localhost/", ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 20))))
+		resp, attempts, err = lblC.DoRequestWithAttempts("127.0.0.1", req)
+		if len(attempts) != 1 {
+			t.Fatalf("large body should not be retried, attempts: %d", len(attempts))
+		}
+		if attempts[0].Backend == "good" {
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "good:"+strings.Repeat("x", 20) {
+				t.Fatalf("body = %s", body)
+			}
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+
+	// 所有后端都尝试过后返回最后一次的结果
+	lblC.SetRetryPolicy(&RetryPolicy{MaxAttempts: 10, RetryOnStatus: []int{http.StatusOK, http.StatusServiceUnavailable}, PerTryTimeout: time.Millisecond * 100})
+	req, _ = http.NewRequest("GET", "http://localhost/", nil)
+	resp, attempts, err = lblC.DoRequestWithAttempts("127.0.0.1", req)
+	if err != nil || len(attempts) != 4 || resp.StatusCode != attempts[3].StatusCode {
+		t.Fatalf("unexpected result %v %d", err, len(attempts))
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultBackendWeight int = 1
+	MaxBackendWeight     int = 10000
+)
+
+var (
+	ErrorInvalidWeight = errors.New("invalid backend weight, must be between 1 and 10000")
+)
+
+// 添加带权重的后端，权重在1到MaxBackendWeight之间，AddBackend添加的后端权重为DefaultBackendWeight。
+// 权重对MethodWeightedRoundrobin、MethodRandom、MethodMinPending(按pending/weight比较)以及基于hash的方式生效
+func (m *LblHttpClient) AddWeightedBackend(addr string, alias string, weight int, healthCheck HealthCheck) error {
+	if weight <= 0 || weight > MaxBackendWeight {
+		return ErrorInvalidWeight
+	}
+	if err := m.AddBackend(addr, alias, healthCheck); err != nil {
+		return err
+	}
+	return m.SetBackendWeight(alias, weight)
+}
+
+// 运行时修改后端的权重
+func (m *LblHttpClient) SetBackendWeight(alias string, weight int) error {
+	if weight <= 0 || weight > MaxBackendWeight {
+		return ErrorInvalidWeight
+	}
+	m.serverListLock.Lock()
+	defer m.serverListLock.Unlock()
+	backend, ok := m.serverMap[alias]
+	if !ok {
+		return ErrorAliasNotExist
+	}
+	atomic.StoreInt64(&backend.weight, int64(weight))
+	m.backendsChanged()
+	return nil
+}
+
+func (m *LblHttpClient) BackendWeight(alias string) (int, error) {
+	m.serverListLock.RLock()
+	defer m.serverListLock.RUnlock()
+	backend, ok := m.serverMap[alias]
+	if !ok {
+		return 0, ErrorAliasNotExist
+	}
+	return int(backend.Weight()), nil
+}
+
+func (m *lblHttpBackend) Weight() int64 {
+	return atomic.LoadInt64(&m.weight)
+}
+
+// 后端或者权重变化后重置平滑加权轮询的状态并重建一致性hash环，调用时持有serverListLock的写锁
+func (m *LblHttpClient) backendsChanged() {
+	m.wrrLock.Lock()
+	defer m.wrrLock.Unlock()
+	m.weighted = false
+	for _, backend := range m.serverList {
+		backend.currentWeight = 0
+		if backend.Weight() != m.serverList[0].Weight() {
+			m.weighted = true
+		}
+	}
+	m.hashRing = newHashRing(m.serverList, m.virtualNodes)
+}
+
+// nginx的平滑加权轮询: 每次选择时每个后端的当前权重加上其权重，选择当前权重最大的后端，被选中的后端的当前权重减去总权重。
+// 权重为5、1、1的后端a、b、c的选择顺序为a a b a c a a
+func (m *LblHttpClient) selectBackendWeightedRoundrobin(servers []*lblHttpBackend) (*lblHttpBackend, error) {
+	m.wrrLock.Lock()
+	defer m.wrrLock.Unlock()
+	var best *lblHttpBackend
+	total := int64(0)
+	for _, backend := range servers {
+		weight := backend.Weight()
+		backend.currentWeight += weight
+		total += weight
+		if best == nil || backend.currentWeight > best.currentWeight {
+			best = backend
+		}
+	}
+	best.currentWeight -= total
+	return best, nil
+}
+
+// 按权重随机选择
+func (m *LblHttpClient) selectBackendWeightedRandom(servers []*lblHttpBackend) (*lblHttpBackend, error) {
+	total := int64(0)
+	for _, backend := range servers {
+		total += backend.Weight()
+	}
+	n := m.randObj.Int63n(total)
+	for _, backend := range servers {
+		if n -= backend.Weight(); n < 0 {
+			return backend, nil
+		}
+	}
+	return servers[len(servers)-1], nil
+}
+
+// 并发安全的随机数源，randObj在并发的请求中使用
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source64
+}
+
+func (m *lockedSource) Int63() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.src.Int63()
+}
+
+func (m *lockedSource) Uint64() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.src.Uint64()
+}
+
+func (m *lockedSource) Seed(seed int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.src.Seed(seed)
+}
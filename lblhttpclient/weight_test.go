@@ -0,0 +1,109 @@
+// Copyright 2021 fangyousong(方友松). All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lblhttpclient
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newWeightedClient(method LoadBalanceMethod, weights ...int) *LblHttpClient {
+	lblC := NewLoadBalanceClient(method, 10, "hashkey", 0, 0)
+	for i, weight := range weights {
+		lblC.AddWeightedBackend(fmt.Sprintf("127.0.0.1:%d", 8001+i), string(rune('a'+i)), weight, nil)
+	}
+	return lblC
+}
+
+func TestWeightedRoundrobin(t *testing.T) {
+	lblC := newWeightedClient(MethodWeightedRoundrobin, 5, 1, 1)
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	var seq []string
+	for i := 0; i < 14; i++ {
+		backend, _ := lblC.selectBackend("", req)
+		seq = append(seq, backend.alias)
+	}
+	if s := strings.Join(seq, ""); s != "aabacaaaabacaa" {
+		t.Fatalf("unexpected sequence %s", s)
+	}
+
+	for _, weight := range []int{0, -1, MaxBackendWeight + 1} {
+		if err := lblC.SetBackendWeight("a", weight); err != ErrorInvalidWeight {
+			t.Fatalf("weight %d, expect ErrorInvalidWeight, got %v", weight, err)
+		}
+	}
+	if err := lblC.AddWeightedBackend("127.0.0.1:9000", "x", MaxBackendWeight+1, nil); err != ErrorInvalidWeight {
+		t.Fatalf("expect ErrorInvalidWeight, got %v", err)
+	}
+	lblC.SetBackendWeight("a", 1)
+	lblC.SetBackendWeight("c", 2)
+	seq = nil
+	for i := 0; i < 4; i++ {
+		backend, _ := lblC.selectBackend("", req)
+		seq = append(seq, backend.alias)
+	}
+	if s := strings.Join(seq, ""); s != "cabc" {
+		t.Fatalf("unexpected sequence after changing weights %s", s)
+	}
+	if w, _ := lblC.BackendWeight("c"); w != 2 {
+		t.Fatalf("weight of c = %d", w)
+	}
+}
+
+func TestWeightedSelection(t *testing.T) {
+	weights := []int{1, 2, 5}
+	for _, method := range []LoadBalanceMethod{MethodRandom, MethodIpHash, MethodUrlParam} {
+		lblC := newWeightedClient(method, weights...)
+		counts := make(map[string]int)
+		n := 80000
+		for i := 0; i < n; i++ {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost/?hashkey=k%d", i), nil)
+			backend, _ := lblC.selectBackend(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255), req)
+			counts[backend.alias]++
+		}
+		for i, weight := range weights {
+			expect := float64(n) * float64(weight) / 8
+			if got := float64(counts[string(rune('a'+i))]); math.Abs(got-expect)/expect > 0.1 {
+				t.Fatalf("method %d: backend %c got %v, expect about %v", method, 'a'+i, got, expect)
+			}
+		}
+	}
+
+	lblC := newWeightedClient(MethodMinPending, 4, 1)
+	lblC.serverMap["a"].pendingRequests = 4
+	lblC.serverMap["b"].pendingRequests = 2
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	for i := 0; i < 4; i++ {
+		if backend, _ := lblC.selectBackend("", req); backend.alias != "a" {
+			t.Fatalf("expect backend with lower pending/weight")
+		}
+	}
+}
+
+// 并发的随机选择，配合-race检查
+func TestConcurrentRandomSelection(t *testing.T) {
+	for _, weights := range [][]int{{1, 1}, {1, 3}} {
+		lblC := newWeightedClient(MethodRandom, weights...)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("GET", "http://localhost/", nil)
+				for j := 0; j < 1000; j++ {
+					if _, err := lblC.selectBackend("", req); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
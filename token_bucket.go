@@ -26,6 +26,12 @@ func NewTokenBucket(capacity int64, qps int64) *TokenBucket {
 		return nil
 	}
 	ret := &TokenBucket{capacity: capacity}
+	// 初始令牌在返回前就绪，创建后可以立即获取令牌
+	rate := qps/10 + 1
+	if ret.capacity < rate {
+		ret.capacity = rate
+	}
+	ret.tokenCount = rate
 	ret.stopNotify = make(chan int, 1)
 	ret.qpsChan = make(chan int64, 1)
 	ret.qpsChan <- qps
@@ -38,10 +44,6 @@ func (m *TokenBucket) run() {
 	ticker := time.NewTicker(time.Millisecond * 100)
 	qps := <-m.qpsChan
 	rate := qps/10 + 1
-	if m.capacity < rate {
-		m.capacity = rate
-	}
-	m.tokenCount = rate
 	for {
 		select {
 		case <-ticker.C:
@@ -84,3 +86,16 @@ func (m *TokenBucket) GetToken() bool {
 		return false
 	}
 }
+
+//归还一个令牌，用于撤销一次GetToken，令牌数不超过容量
+func (m *TokenBucket) ReturnToken() {
+	for {
+		tokenCount := atomic.LoadInt64(&m.tokenCount)
+		if tokenCount >= m.capacity {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&m.tokenCount, tokenCount, tokenCount+1) {
+			return
+		}
+	}
+}